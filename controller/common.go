@@ -2,8 +2,10 @@
 package controller
 
 import (
+	"errors"                   //错误处理
 	"fmt"                      //格式化
 	"github.com/gin-gonic/gin" //gin框架
+	"golang.org/x/crypto/ssh"  //ssh库
 	"time"                     //时间日期库
 	"webssh/core"              //本地core库操作SSH及SFTP用
 )
@@ -13,6 +15,7 @@ type ResponseBody struct {
 	Duration string      //时长
 	Data     interface{} //数据
 	Msg      string      //消息
+	Code     string      `json:",omitempty"` //错误码，见core包中的Code常量
 }
 
 // TimeCost 响应耗时计算
@@ -20,26 +23,143 @@ func TimeCost(start time.Time, body *ResponseBody) {
 	body.Duration = time.Since(start).String()
 }
 
-// CheckSSH 检查ssh连接是否能连接
-func CheckSSH(c *gin.Context) *ResponseBody {
-	responseBody := ResponseBody{Msg: "success"}          //初始化响应成功消息体
-	defer TimeCost(time.Now(), &responseBody)             //响应时长计算
-	sshInfo := c.DefaultQuery("sshInfo", "")              //查询SSH信息
-	sshClient, err := core.DecodedMsgToSSHClient(sshInfo) //解决SSH信息为SSH客户端
-	//响应出错,替换错误信息
+// decodeSSHInfo 解析sshInfo为SSH客户端，并记录当前登录的web用户
+func decodeSSHInfo(c *gin.Context, sshInfo string) (core.SSHClient, error) {
+	sshClient, err := core.DecodedMsgToSSHClient(sshInfo)
+	sshClient.WebUser = c.GetString(gin.AuthUserKey) //未开启账号验证时为空
+	sshClient.ClientIP = c.ClientIP()                //写入审计日志
+	return sshClient, err
+}
+
+// CAPublicKey 返回内置CA的公钥
+func CAPublicKey(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	if core.CA == nil {
+		responseBody.Msg = "certificate authority is not configured"
+		responseBody.Code = core.CodeCAUnavailable
+		return &responseBody
+	}
+	responseBody.Data = map[string]interface{}{
+		"publicKey": core.CA.PublicKey(), //authorized_keys格式公钥
+	}
+	return &responseBody
+}
+
+// sessionToken 取请求中的会话令牌
+// websocket无法设置请求头，同时支持请求头、查询参数及表单
+func sessionToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Session-Token"); token != "" {
+		return token
+	}
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	return c.PostForm("token")
+}
+
+// acquireSession 按令牌取会话的SSH客户端，使用结束后调用release
+// challenge : 键盘交互认证问题转发，只有终端可以提供
+func acquireSession(c *gin.Context, challenge ssh.KeyboardInteractiveChallenge) (*core.SSHClient, func(), error) {
+	sshClient, release, err := core.Sessions.Acquire(sessionToken(c), c.GetString(gin.AuthUserKey), challenge)
+	if err == nil {
+		sshClient.ClientIP = c.ClientIP() //审计日志记录本次请求的IP
+	}
+	return sshClient, release, err
+}
+
+// 主机密钥校验结果及协商算法
+func connectionInfo(sshClient *core.SSHClient, data map[string]interface{}) map[string]interface{} {
+	//跳板机主机密钥校验结果
+	jumpHostKeys := make([]core.HostKeyResult, 0, len(sshClient.JumpHosts))
+	for _, hop := range sshClient.JumpHosts {
+		jumpHostKeys = append(jumpHostKeys, hop.HostKey)
+	}
+	data["hostKey"] = sshClient.HostKey       //主机密钥校验结果
+	data["jumpHostKeys"] = jumpHostKeys       //跳板机主机密钥校验结果
+	data["algorithms"] = sshClient.Negotiated //与目标主机协商出的算法
+	return data
+}
+
+// CreateSession 认证ssh连接并登记会话，返回会话令牌
+// sshInfo通过POST表单提交，不会出现在代理及访问日志中
+// savePass : 是否允许浏览器保存密码
+func CreateSession(c *gin.Context, savePass bool) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //初始化响应成功消息体
+	defer TimeCost(time.Now(), &responseBody)    //响应时长计算
+	sshInfo := c.PostForm("sshInfo")             //SSH信息
+	sshClient, err := decodeSSHInfo(c, sshInfo)  //解析SSH信息为SSH客户端
 	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
 		return &responseBody
 	}
-	//生成SSH客户端
-	err = sshClient.GenerateClient()
-	defer sshClient.Close() //关闭SSH客户端
+	//认证并登记会话
+	entry, err := core.Sessions.Create(&sshClient)
+	if err != nil {
+		audit := core.NewAuditEvent(core.AuditLoginFailed, &sshClient)
+		audit.Error = err.Error()
+		core.Audit(audit)
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		//返回主机密钥校验结果，便于浏览器展示指纹
+		responseBody.Data = connectionInfo(&sshClient, map[string]interface{}{"savePass": savePass})
+		return &responseBody
+	}
+	//键盘交互认证的会话在打开终端完成认证后记录登录
+	if !entry.Pending() {
+		audit := core.NewAuditEvent(core.AuditLogin, &sshClient)
+		audit.Session = core.SessionID(entry.Token)
+		core.Audit(audit)
+	}
+	responseBody.Data = connectionInfo(entry.Client(), map[string]interface{}{
+		"savePass": savePass,                           //保存连接密码
+		"token":    entry.Token,                        //会话令牌
+		"ttl":      int(core.SessionTTL / time.Second), //会话空闲过期时间(s)
+		"pending":  entry.Pending(),                    //是否需要在终端中完成键盘交互认证
+	})
+	return &responseBody
+}
+
+// CloseSession 关闭会话
+func CloseSession(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //初始化响应成功消息体
+	defer TimeCost(time.Now(), &responseBody)    //响应时长计算
+	if err := core.Sessions.Remove(sessionToken(c), c.GetString(gin.AuthUserKey)); err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+	}
+	return &responseBody
+}
+
+// CheckSSH 检查会话的ssh连接是否可用
+// savePass : 是否允许浏览器保存密码
+func CheckSSH(c *gin.Context, savePass bool) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"}      //初始化响应成功消息体
+	defer TimeCost(time.Now(), &responseBody)         //响应时长计算
+	sshClient, release, err := acquireSession(c, nil) //按令牌取SSH客户端
 	//响应出错,替换错误信息
 	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	defer release()
+	defer sshClient.Close()
+	//发送keepalive请求确认连接仍然可用
+	if _, _, err := sshClient.Client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		err = &core.CodeError{Code: core.CodeSessionNotFound, Err: errors.New("ssh connection closed")}
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
 	}
+	//响应数据，返回主机密钥指纹供浏览器展示
+	responseBody.Data = connectionInfo(sshClient, map[string]interface{}{
+		"savePass": savePass, //保存连接密码
+	})
 	//返回响应信息
 	return &responseBody
 }
@@ -0,0 +1,88 @@
+// Package controller : 控制器
+package controller
+
+import (
+	"fmt"                      //格式化
+	"github.com/gin-gonic/gin" //Gin框架
+	"strconv"                  //字符串转换库
+	"time"                     //时间日期库
+	"webssh/core"              //本地core库，用于处理SSH与SFTP
+)
+
+// Exec 不分配pty执行命令，返回stdout、stderr、退出码、信号及执行时间
+// 表单字段command为命令，timeout为超时时间(s)，超时后终止远程进程
+func Exec(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	timeout, _ := strconv.Atoi(c.DefaultPostForm("timeout", "0"))
+	command := c.PostForm("command")
+	//按令牌取SSH客户端
+	sshClient, release, err := acquireSession(c, nil)
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	defer release()
+	defer sshClient.Close()
+	//执行命令并写入审计日志
+	audit := core.NewAuditEvent(core.AuditExec, sshClient)
+	audit.Session, audit.Command = core.SessionID(sessionToken(c)), command
+	defer func() { core.Audit(audit) }()
+	result, err := sshClient.Exec(command, time.Duration(timeout)*time.Second)
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		audit.Error = err.Error()
+		return &responseBody
+	}
+	audit.ExitStatus, audit.ExitSignal = result.ExitStatus, result.ExitSignal
+	if result.TimedOut {
+		audit.Reason = "timeout"
+	}
+	responseBody.Data = result
+	return &responseBody
+}
+
+// ExecBatch 在多台主机上并发执行命令，以SSE逐个返回每台主机的结果，最后返回汇总
+// 请求体为JSON: hosts(主机列表)、group(主机组名)、auth(主机未填写的认证信息)、command、concurrency、timeout
+// 事件result为单台主机的结果，summary为汇总；请求无效时返回普通JSON响应
+func ExecBatch(c *gin.Context) {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	start := time.Now()                          //响应耗时计算
+	var req core.BatchRequest
+	var hosts []core.BatchHost
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		hosts, err = req.Resolve()
+	}
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		TimeCost(start, &responseBody)
+		c.JSON(200, &responseBody)
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") //nginx反向代理时不缓冲
+	//浏览器断开后不再连接新的主机
+	stop := c.Request.Context().Done()
+	summary := req.RunBatch(hosts, c.GetString(gin.AuthUserKey), c.ClientIP(), stop, func(result core.BatchResult) {
+		c.SSEvent("result", result)
+		c.Writer.Flush()
+	})
+	c.SSEvent("summary", summary)
+	c.Writer.Flush()
+}
+
+// ExecGroups 列出主机组名及主机数
+func ExecGroups(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	responseBody.Data = map[string]interface{}{
+		"groups": core.HostGroups(), //主机组名到主机数的映射
+	}
+	return &responseBody
+}
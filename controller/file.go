@@ -101,26 +101,24 @@ func (f fileSplice) Less(i, j int) bool { return f[i].IsDir }
 
 // UploadFile 上传文件
 func UploadFile(c *gin.Context) *ResponseBody {
-	//局部变量
-	var (
-		sshClient core.SSHClient //SSH客户端
-		err       error          //错误接口
-	)
 	responseBody := ResponseBody{Msg: "success"} //响应成功信息
 	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
-	sshInfo := c.PostForm("sshInfo")             //SSH客户端信息
 	//取POST提交标识
 	id := c.PostForm("id")
-	//JSON反序列化sshInfo为SSHClient对象
-	if sshClient, err = core.DecodedMsgToSSHClient(sshInfo); err != nil {
+	//按令牌取SSH客户端
+	sshClient, release, err := acquireSession(c, nil)
+	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error() //出错，替换错误响应消息
+		responseBody.Code = core.ErrorCode(err)
 		return &responseBody
 	}
+	defer release()
 	//创建SFTP客户端
 	if err := sshClient.CreateSftp(); err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error() //出错，替换错误响应消息
+		responseBody.Code = core.ErrorCode(err)
 		return &responseBody
 	}
 	defer sshClient.Close() //关闭SFTP客户端
@@ -145,51 +143,56 @@ func UploadFile(c *gin.Context) *ResponseBody {
 	}
 	pathArr = append(pathArr, header.Filename) //拼接路径与文件名
 	//上传文件到指定路径
-	err = sshClient.Upload(file, id, strings.Join(pathArr, "/"))
+	audit := core.NewAuditEvent(core.AuditUpload, sshClient)
+	audit.Session, audit.Path, audit.Size = core.SessionID(sessionToken(c)), strings.Join(pathArr, "/"), header.Size
+	err = sshClient.Upload(file, id, audit.Path)
 	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		audit.Error = err.Error()
 	}
+	core.Audit(audit) //写入审计日志
 	return &responseBody
 }
 
 // DownloadFile 下载文件
 func DownloadFile(c *gin.Context) *ResponseBody {
-	//局部变量
-	var (
-		sshClient core.SSHClient //SSH客户端
-		err       error          //错误接口
-	)
 	responseBody := ResponseBody{Msg: "success"} //响应成功消息
 	defer TimeCost(time.Now(), &responseBody)    //响应时间计算
 	//路径处理
 	path := strings.TrimSpace(c.DefaultQuery("path", "/root"))
-	//查询SSH信息
-	sshInfo := c.DefaultQuery("sshInfo", "")
-	//JSON反序列sshInfo为SSHClient对象
-	if sshClient, err = core.DecodedMsgToSSHClient(sshInfo); err != nil {
+	//按令牌取SSH客户端
+	sshClient, release, err := acquireSession(c, nil)
+	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
 		return &responseBody
 	}
+	defer release()
 	//创建SFTP客户端
 	if err := sshClient.CreateSftp(); err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
 		return &responseBody
 	}
 	defer sshClient.Close() //关闭SSH客户端
 	//下载文件
+	audit := core.NewAuditEvent(core.AuditDownload, sshClient)
+	audit.Session, audit.Path = core.SessionID(sessionToken(c)), path
+	defer func() { core.Audit(audit) }() //写入审计日志
 	if sftpFile, err := sshClient.Download(path); err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		audit.Error = err.Error()
 	} else {
 		defer sftpFile.Close()              //关闭SFTP客户端
 		c.Writer.WriteHeader(http.StatusOK) //写HTTP状态码
 		fileMeta := strings.Split(path, "/")
 		//设置HTTP头
 		c.Header("Content-Disposition", "attachment; filename="+fileMeta[len(fileMeta)-1])
-		_, _ = io.Copy(c.Writer, sftpFile) //复制文件到客户端
+		audit.Size, _ = io.Copy(c.Writer, sftpFile) //复制文件到客户端
 	}
 	return &responseBody
 }
@@ -244,25 +247,27 @@ func UploadProgressWs(c *gin.Context) *ResponseBody {
 
 // FileList 获取文件列表
 func FileList(c *gin.Context) *ResponseBody {
-	responseBody := ResponseBody{Msg: "success"}          //响应成功消息，处理Msg字段
-	defer TimeCost(time.Now(), &responseBody)             //响应耗时计算，处理Duration字段
-	path := c.DefaultQuery("path", "/root")               //路径查找
-	sshInfo := c.DefaultQuery("sshInfo", "")              //SSH客户端信息查找
-	sshClient, err := core.DecodedMsgToSSHClient(sshInfo) //SSH客户端信息JSON反序列化为SSHClient
-	//JSON反序列化失败
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息，处理Msg字段
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算，处理Duration字段
+	path := c.DefaultQuery("path", "/root")      //路径查找
+	//按令牌取SSH客户端
+	sshClient, release, err := acquireSession(c, nil)
 	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
 		return &responseBody
 	}
+	defer release()
 	//创建SFTP客户端
 	if err := sshClient.CreateSftp(); err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
 		return &responseBody
 	}
-	defer sshClient.Close()                    //关闭SFTP客户端
-	files, err := sshClient.Sftp.ReadDir(path) //读取指定路径文件与文件夹列表
+	defer sshClient.Close()               //关闭SFTP客户端
+	files, err := sshClient.ReadDir(path) //读取指定路径文件与文件夹列表，文件名按远程主机的编码转换
 	//读取文件夹失败w
 	if err != nil {
 		if strings.Contains(err.Error(), "exist") {
@@ -0,0 +1,65 @@
+// Package controller : 控制器
+package controller
+
+import (
+	"fmt"                      //格式化
+	"github.com/gin-gonic/gin" //gin框架
+	"strings"                  //字符串库
+	"time"                     //时间日期库
+	"webssh/core"              //本地core库，管理known_hosts
+)
+
+// HostKeyList 列出known_hosts中已记录的主机密钥
+func HostKeyList(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	entries, err := core.HostKeys.List()         //读取全部记录
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		return &responseBody
+	}
+	//过滤主机，为空时返回全部
+	if host := c.Query("host"); host != "" {
+		filtered := make([]core.HostKeyEntry, 0)
+		for _, entry := range entries {
+			if entry.Matches(host) {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	responseBody.Data = map[string]interface{}{
+		"policy": core.HostKeys.Policy(), //校验策略
+		"list":   entries,                //记录列表
+	}
+	return &responseBody
+}
+
+// HostKeyRemove 删除指定主机已记录的密钥，主机重装后需先删除旧密钥才能重新连接
+func HostKeyRemove(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	//删除记录后任何人都可以冒充该主机被重新信任，只允许-hka中的web用户操作
+	if !core.HostKeys.CanRemove(c.GetString(gin.AuthUserKey)) {
+		responseBody.Msg = "removing host keys is not allowed for this user"
+		responseBody.Code = core.CodeHostKeyForbidden
+		return &responseBody
+	}
+	host := strings.TrimSpace(c.PostForm("host"))
+	if host == "" {
+		responseBody.Msg = "host is required"
+		return &responseBody
+	}
+	//指纹为空时删除该主机的全部密钥
+	removed, err := core.HostKeys.Remove(host, strings.TrimSpace(c.PostForm("fingerprint")))
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		return &responseBody
+	}
+	responseBody.Data = map[string]interface{}{
+		"removed": removed, //删除的记录数
+	}
+	return &responseBody
+}
@@ -0,0 +1,121 @@
+// Package controller : 控制器
+package controller
+
+import (
+	"fmt"                      //格式化
+	"github.com/gin-gonic/gin" //Gin框架
+	"net/http"                 //http库
+	"strconv"                  //字符串转换库
+	"time"                     //时间日期库
+	"webssh/core"              //本地core库
+)
+
+// 解析查询参数中的时间，支持RFC3339及unix时间戳(s)
+func parseTimeQuery(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid %s %q, want RFC3339 or unix timestamp", key, value)
+	}
+	return t, nil
+}
+
+// RecordList 列出当前web用户的终端录像
+// 查询参数user、host为通配符，from、to为开始时间范围
+func RecordList(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	filter := core.RecordingFilter{
+		WebUser: c.GetString(gin.AuthUserKey),
+		User:    c.Query("user"),
+		Host:    c.Query("host"),
+	}
+	var err error
+	if filter.From, err = parseTimeQuery(c, "from"); err == nil {
+		filter.To, err = parseTimeQuery(c, "to")
+	}
+	var list []core.RecordingInfo
+	if err == nil {
+		list, err = core.ListRecordings(filter)
+	}
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	responseBody.Data = map[string]interface{}{
+		"list": list, //录像列表
+	}
+	return &responseBody
+}
+
+// RecordInfo 取录像元数据
+func RecordInfo(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	info, err := core.GetRecording(c.Query("name"), c.GetString(gin.AuthUserKey))
+	if err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	responseBody.Data = info
+	return &responseBody
+}
+
+// RecordPlay 通过websocket回放录像
+// 查询参数speed为初始回放速度，idle为最长空闲时间(s)
+func RecordPlay(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	speed, _ := strconv.ParseFloat(c.DefaultQuery("speed", "1"), 64)
+	idle, _ := strconv.ParseFloat(c.DefaultQuery("idle", "0"), 64)
+	info, err := core.GetRecording(c.Query("name"), c.GetString(gin.AuthUserKey))
+	if err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		c.JSON(200, responseBody)
+		return &responseBody
+	}
+	//升级HTTP连接为Websocket连接
+	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		return &responseBody
+	}
+	if err := core.Playback(wsConn, info, speed, idle); err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+	}
+	return &responseBody
+}
+
+// RecordExport 把录像导出为纯文本文件
+func RecordExport(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	info, err := core.GetRecording(c.Query("name"), c.GetString(gin.AuthUserKey))
+	if err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		c.JSON(200, responseBody)
+		return &responseBody
+	}
+	//设置HTTP头
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+info.Name+".txt")
+	c.Writer.WriteHeader(http.StatusOK)
+	if err := core.ExportRecording(c.Writer, info); err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+	}
+	return &responseBody
+}
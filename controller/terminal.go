@@ -2,6 +2,7 @@
 package controller
 
 import (
+	"errors"                       //错误处理
 	"fmt"                          //格式化
 	"github.com/gin-gonic/gin"     //Gin框架
 	"github.com/gorilla/websocket" //websocket库
@@ -22,13 +23,13 @@ var upgrader = websocket.Upgrader{
 }
 
 // TermWs 获取终端websocket
+// 查询参数id为浏览器生成的终端ID，终端还在服务端保留期内时重新连接并回放offset之后的输出
 // c: Gin框架上下文
 // timeout: 连接超时
 // 返回ResponseBody结构
 func TermWs(c *gin.Context, timeout time.Duration) *ResponseBody {
 	responseBody := ResponseBody{Msg: "success"} //响应成功消息
 	defer TimeCost(time.Now(), &responseBody)    //响应超时计算
-	sshInfo := c.DefaultQuery("sshInfo", "")     //查询SSH客户端信息
 	//查询行与列值,如空，使用默认值
 	cols := c.DefaultQuery("cols", "150")
 	rows := c.DefaultQuery("rows", "35")
@@ -37,32 +38,239 @@ func TermWs(c *gin.Context, timeout time.Duration) *ResponseBody {
 	//转换为整数
 	col, _ := strconv.Atoi(cols)
 	row, _ := strconv.Atoi(rows)
-	//SSH客户端信息反序列化为SSHClient对象
-	sshClient, err := core.DecodedMsgToSSHClient(sshInfo)
+	//终端ID及已收到的输出字节数
+	id := c.Query("id")
+	offset, _ := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	//升级HTTP连接为Websocket连接
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	//升级失败
 	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
 		return &responseBody
 	}
-	//升级HTTP连接为Websocket连接
-	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	//升级失败
+	//按查询参数protocol选择协议版本，未指定时为旧的文本协议
+	wsConn := core.NewTermConn(ws, core.ParseProtocol(c.Query("protocol")))
+	//按令牌取SSH客户端，键盘交互认证的问题通过终端转发给浏览器
+	sshClient, release, err := acquireSession(c, core.WsChallenge(wsConn))
+	//出错
+	if err != nil {
+		wsConn.Notice(err.Error()) //向Websocket客户端发送错误信息
+		wsConn.Close()             //关闭Websocket连接
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	//显示给共享观看者的名字
+	name := c.GetString(gin.AuthUserKey)
+	if name == "" {
+		name = core.RoleOwner
+	}
+	//重新连接保留中的终端
+	if term := core.Terminals.Get(id, sessionToken(c)); term != nil {
+		sshClient.Close()
+		release() //终端持有自己的会话引用
+		sub, err := term.Attach(wsConn, name, offset, row, col, closeTip)
+		if err != nil {
+			fmt.Println(err)
+			responseBody.Msg = err.Error()
+			return &responseBody
+		}
+		term.Serve(sub)
+		return &responseBody
+	}
+	//首次连接时在终端中提示已记录的主机密钥指纹
+	for _, hostKey := range core.Sessions.TakeAddedHostKeys(sessionToken(c)) {
+		wsConn.Notice(fmt.Sprintf(
+			"\u001B[33mWarning: Permanently added '%s' (%s) to the list of known hosts.\r\nFingerprint: %s\u001B[0m\r\n",
+			hostKey.Host, hostKey.Type, hostKey.Fingerprint))
+	}
+	//打开终端
+	term, err := core.NewTerminal(sshClient, release, id, sessionToken(c), row, col, timeout)
+	if err != nil {
+		wsConn.Notice(err.Error())
+		wsConn.Close()
+		sshClient.Close()
+		release()
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		return &responseBody
+	}
+	sub, err := term.Attach(wsConn, name, 0, 0, 0, closeTip)
 	if err != nil {
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
 		return &responseBody
 	}
-	//生成SSH客户端
-	err = sshClient.GenerateClient()
-	//生成出错
+	term.Serve(sub)
+	return &responseBody
+}
+
+// TermList 列出会话中保留的终端
+func TermList(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	//校验令牌
+	if err := checkSession(c); err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	responseBody.Data = map[string]interface{}{
+		"list": core.Terminals.List(sessionToken(c)), //终端列表
+	}
+	return &responseBody
+}
+
+// TermMetrics 终端输出合并及流控统计，包括暂停读取ssh输出及断开的慢连接
+func TermMetrics(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	responseBody.Data = core.GetOutputMetrics()
+	return &responseBody
+}
+
+// 校验会话令牌属于当前web用户，等待键盘交互认证的会话同样有效
+func checkSession(c *gin.Context) error {
+	sshClient, release, err := acquireSession(c, nil)
+	if err != nil {
+		if core.ErrorCode(err) == core.CodeInteractiveRequired {
+			return nil
+		}
+		return err
+	}
+	sshClient.Close()
+	release()
+	return nil
+}
+
+// 按令牌及终端ID取当前会话的终端
+func ownedTerminal(c *gin.Context) (*core.Terminal, error) {
+	if err := checkSession(c); err != nil {
+		return nil, err
+	}
+	id := c.Query("id")
+	if id == "" {
+		id = c.PostForm("id")
+	}
+	if term := core.Terminals.Get(id, sessionToken(c)); term != nil {
+		return term, nil
+	}
+	return nil, &core.CodeError{Code: core.CodeTerminalNotFound, Err: errors.New("terminal not found or closed")}
+}
+
+// TermJoin 通过邀请链接连接共享的终端
+// 查询参数invite为邀请令牌，name为显示给其他连接的名字
+func TermJoin(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	invite := c.Query("invite")
+	offset, _ := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	//未指定名字时使用web用户名
+	name := c.Query("name")
+	if name == "" {
+		name = c.GetString(gin.AuthUserKey)
+	}
+	if name == "" {
+		name = "guest"
+	}
+	//升级HTTP连接为Websocket连接
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		wsConn.WriteMessage(1, []byte(err.Error())) //向Websocket客户端发送错误信息
-		wsConn.Close()                              //关闭Websocket连接
 		fmt.Println(err)
 		responseBody.Msg = err.Error()
 		return &responseBody
 	}
-	sshClient.InitTerminal(wsConn, row, col)     //初始化终端
-	sshClient.Connect(wsConn, timeout, closeTip) //连接Websocket
+	wsConn := core.NewTermConn(ws, core.ParseProtocol(c.Query("protocol")))
+	term, err := core.Terminals.ByInvite(invite)
+	if err == nil {
+		var sub *core.Subscriber
+		if sub, err = term.Join(wsConn, name, invite, offset); err == nil {
+			//审计日志记录观看者自己的web用户及IP
+			audit := term.NewAuditEvent(core.AuditTerminalJoin)
+			audit.WebUser, audit.ClientIP = c.GetString(gin.AuthUserKey), c.ClientIP()
+			audit.Actor, audit.Role = sub.Name, sub.Role
+			core.Audit(audit)
+			term.Serve(sub)
+			return &responseBody
+		}
+	}
+	wsConn.Notice(err.Error())
+	//终端已关闭时浏览器不需要重新连接
+	wsConn.CloseWith(websocket.CloseNormalClosure, err.Error())
+	fmt.Println(err)
+	responseBody.Msg = err.Error()
+	responseBody.Code = core.ErrorCode(err)
+	return &responseBody
+}
+
+// TermInvite 为终端生成邀请令牌
+// 表单字段role为rw(获得控制权后可以输入)或ro(只能观看)
+func TermInvite(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	term, err := ownedTerminal(c)
+	if err == nil {
+		var invite string
+		role := c.DefaultPostForm("role", core.RoleReadOnly)
+		if invite, err = term.Invite(role); err == nil {
+			responseBody.Data = map[string]interface{}{
+				"invite": invite, //邀请令牌
+				"role":   role,   //角色
+			}
+			return &responseBody
+		}
+	}
+	fmt.Println(err)
+	responseBody.Msg = err.Error()
+	responseBody.Code = core.ErrorCode(err)
+	return &responseBody
+}
+
+// TermRevoke 撤销邀请并断开通过该邀请连接的观看者
+func TermRevoke(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	term, err := ownedTerminal(c)
+	if err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	term.Revoke(c.Query("invite"))
+	return &responseBody
+}
+
+// TermUsers 列出连接到终端的所有者及观看者
+func TermUsers(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	term, err := ownedTerminal(c)
+	if err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	responseBody.Data = map[string]interface{}{
+		"list": term.Users(), //连接列表
+	}
+	return &responseBody
+}
+
+// TermControl 把键盘控制权转移给指定连接
+// 表单字段user为连接ID，转移给所有者的连接即收回控制权
+func TermControl(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	term, err := ownedTerminal(c)
+	if err == nil {
+		err = term.SetControl(c.PostForm("user"))
+	}
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+	}
 	return &responseBody
 }
@@ -0,0 +1,121 @@
+// Package controller : 控制器
+package controller
+
+import (
+	"errors"                       //错误处理
+	"fmt"                          //格式化
+	"github.com/gin-gonic/gin"     //Gin框架
+	"github.com/gorilla/websocket" //websocket库
+	"strconv"                      //字符串转换库
+	"strings"                      //字符串库
+	"time"                         //时间日期库
+	"webssh/core"                  //本地core库，用于处理SSH与SFTP
+)
+
+// TunnelCreate 创建监听端口的隧道
+// 表单字段type为local(默认)、remote或socks，target为目标地址(主机:端口)，port为监听端口(为0时随机分配)
+// local: 在webssh服务器上监听，连接经会话的SSH连接转发到target，target由SSH服务器连接
+// allow为允许连接的来源IP或CIDR，逗号分隔，为空时只允许当前浏览器的IP
+// remote: 在SSH服务器的bind地址上监听(ssh -R)，连接转发回target，target由webssh服务器连接
+// socks: 在webssh服务器上监听SOCKS5代理(ssh -D)，allow同local，auth为true时生成代理的用户名及密码
+func TunnelCreate(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	port, _ := strconv.Atoi(c.DefaultPostForm("port", "0"))
+	var allow []string
+	if value := c.PostForm("allow"); value != "" {
+		allow = strings.Split(value, ",")
+	}
+	//按令牌取SSH客户端，隧道持有自己的会话引用
+	sshClient, release, err := acquireSession(c, nil)
+	if err == nil {
+		var tunnel *core.Tunnel
+		switch c.DefaultPostForm("type", core.TunnelLocal) {
+		case core.TunnelRemote:
+			tunnel, err = core.NewRemoteTunnel(sshClient, release, sessionToken(c), c.PostForm("bind"), port, c.PostForm("target"))
+		case core.TunnelSocks:
+			auth, _ := strconv.ParseBool(c.DefaultPostForm("auth", "false"))
+			tunnel, err = core.NewSocksTunnel(sshClient, release, sessionToken(c), port, allow, auth)
+		default:
+			tunnel, err = core.NewLocalTunnel(sshClient, release, sessionToken(c), port, c.PostForm("target"), allow)
+		}
+		if err == nil {
+			info := tunnel.Info()
+			info.Password = tunnel.Password() //代理密码只在创建时返回
+			responseBody.Data = info
+			return &responseBody
+		}
+		sshClient.Close()
+		release()
+	}
+	fmt.Println(err)
+	responseBody.Msg = err.Error()
+	responseBody.Code = core.ErrorCode(err)
+	return &responseBody
+}
+
+// TunnelWs websocket隧道，二进制消息与目标地址之间双向转发字节流
+// 查询参数target为目标地址，本地工具可以把TCP连接桥接到该websocket
+func TunnelWs(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	//升级HTTP连接为Websocket连接
+	wsConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Println(err)
+		responseBody.Msg = err.Error()
+		return &responseBody
+	}
+	sshClient, release, err := acquireSession(c, nil)
+	if err == nil {
+		var tunnel *core.Tunnel
+		if tunnel, err = core.NewStreamTunnel(sshClient, release, sessionToken(c), c.Query("target"), wsConn); err == nil {
+			tunnel.Serve()
+			return &responseBody
+		}
+		sshClient.Close()
+		release()
+	}
+	//字节流中不能混入文本，错误放在关闭帧中
+	wsConn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()), time.Now().Add(time.Second))
+	wsConn.Close()
+	fmt.Println(err)
+	responseBody.Msg = err.Error()
+	responseBody.Code = core.ErrorCode(err)
+	return &responseBody
+}
+
+// TunnelList 列出会话的隧道
+func TunnelList(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	//校验令牌
+	if err := checkSession(c); err != nil {
+		responseBody.Msg = err.Error()
+		responseBody.Code = core.ErrorCode(err)
+		return &responseBody
+	}
+	responseBody.Data = map[string]interface{}{
+		"list": core.Tunnels.List(sessionToken(c)), //隧道列表
+	}
+	return &responseBody
+}
+
+// TunnelClose 关闭隧道及其全部转发连接
+func TunnelClose(c *gin.Context) *ResponseBody {
+	responseBody := ResponseBody{Msg: "success"} //响应成功消息
+	defer TimeCost(time.Now(), &responseBody)    //响应耗时计算
+	err := checkSession(c)
+	if err == nil {
+		if tunnel := core.Tunnels.Get(c.Query("id"), sessionToken(c)); tunnel != nil {
+			tunnel.Close()
+			return &responseBody
+		}
+		err = &core.CodeError{Code: core.CodeTunnelNotFound, Err: errors.New("tunnel not found or closed")}
+	}
+	fmt.Println(err)
+	responseBody.Msg = err.Error()
+	responseBody.Code = core.ErrorCode(err)
+	return &responseBody
+}
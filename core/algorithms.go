@@ -0,0 +1,319 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"                   //字节切片
+	"encoding/binary"         //解析KEXINIT报文
+	"fmt"                     //格式化
+	"golang.org/x/crypto/ssh" //ssh库
+	"net"                     //网络库
+	"path"                    //主机名通配符匹配
+	"strings"                 //字符串库
+	"sync"                    //互斥锁
+)
+
+// CodeAlgorithmMismatch 算法配置与已记录的主机密钥类型或服务端不兼容的错误码
+const CodeAlgorithmMismatch = "ALGORITHM_MISMATCH"
+
+// 算法配置名称
+const (
+	AlgorithmsModern     = "modern"     //只使用现代算法
+	AlgorithmsCompatible = "compatible" //现代算法加上仍被广泛使用的sha1算法，默认
+	AlgorithmsLegacy     = "legacy"     //兼容老旧网络设备，包含group1、ssh-rsa、ssh-dss、cbc等弱算法
+)
+
+// AlgorithmProfile SSH算法配置，按优先顺序排列
+type AlgorithmProfile struct {
+	KeyExchanges      []string //密钥交换算法
+	Ciphers           []string //加密算法
+	MACs              []string //消息认证算法
+	HostKeyAlgorithms []string //主机密钥算法
+}
+
+// 各配置的算法列表
+var (
+	modernKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+	}
+	modernCiphers = []string{
+		"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+	}
+	modernMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256", "hmac-sha2-512",
+	}
+	modernHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	}
+)
+
+// AlgorithmProfiles 可选的算法配置
+var AlgorithmProfiles = map[string]AlgorithmProfile{
+	AlgorithmsModern: {
+		KeyExchanges:      modernKeyExchanges,
+		Ciphers:           modernCiphers,
+		MACs:              modernMACs,
+		HostKeyAlgorithms: modernHostKeyAlgorithms,
+	},
+	AlgorithmsCompatible: {
+		KeyExchanges:      concat(modernKeyExchanges, "diffie-hellman-group-exchange-sha256", "diffie-hellman-group14-sha1"),
+		Ciphers:           modernCiphers,
+		MACs:              concat(modernMACs, "hmac-sha1"),
+		HostKeyAlgorithms: concat(modernHostKeyAlgorithms, ssh.KeyAlgoRSA),
+	},
+	AlgorithmsLegacy: {
+		KeyExchanges: concat(modernKeyExchanges, "diffie-hellman-group-exchange-sha256", "diffie-hellman-group14-sha1",
+			"diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1"),
+		Ciphers:           concat(modernCiphers, "aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour"),
+		MACs:              concat(modernMACs, "hmac-sha1", "hmac-sha1-96"),
+		HostKeyAlgorithms: concat(modernHostKeyAlgorithms, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA),
+	},
+}
+
+// DefaultAlgorithms 服务端默认算法配置
+var DefaultAlgorithms = AlgorithmsCompatible
+
+// 按主机配置的算法，按配置顺序匹配
+var hostAlgorithms []hostAlgorithm
+
+// 主机名通配符与算法配置
+type hostAlgorithm struct {
+	pattern string //主机名通配符，如10.0.0.*
+	profile string //算法配置名称
+}
+
+// 拼接算法列表，返回新的切片
+func concat(list []string, more ...string) []string {
+	return append(append(make([]string, 0, len(list)+len(more)), list...), more...)
+}
+
+// InitAlgorithms 初始化算法配置
+// defaultProfile : 默认算法配置名称
+// hostProfiles : 按主机配置的算法，格式为 通配符=配置名称,通配符=配置名称
+func InitAlgorithms(defaultProfile, hostProfiles string) error {
+	if _, ok := AlgorithmProfiles[defaultProfile]; !ok {
+		return fmt.Errorf("unknown algorithm profile %q", defaultProfile)
+	}
+	var hosts []hostAlgorithm
+	for _, item := range strings.Split(hostProfiles, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		pattern, profile, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid host algorithm profile %q, want pattern=profile", item)
+		}
+		if _, ok := AlgorithmProfiles[profile]; !ok {
+			return fmt.Errorf("unknown algorithm profile %q", profile)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid host pattern %q: %v", pattern, err)
+		}
+		hosts = append(hosts, hostAlgorithm{pattern: pattern, profile: profile})
+	}
+	DefaultAlgorithms, hostAlgorithms = defaultProfile, hosts
+	return nil
+}
+
+// 选择连接使用的算法配置，sshInfo中指定的优先，其次是按主机配置的，最后是默认配置
+func (sclient *SSHClient) algorithmProfile() (string, AlgorithmProfile, error) {
+	name := sclient.Algorithms
+	if name == "" {
+		name = DefaultAlgorithms
+		host := strings.Trim(sclient.IPAddress, "[]")
+		for _, item := range hostAlgorithms {
+			if matched, _ := path.Match(item.pattern, host); matched {
+				name = item.profile
+				break
+			}
+		}
+	}
+	profile, ok := AlgorithmProfiles[name]
+	if !ok {
+		return name, profile, &CodeError{Code: CodeAlgorithmMismatch, Err: fmt.Errorf("unknown algorithm profile %q", name)}
+	}
+	return name, profile, nil
+}
+
+// 取算法配置允许的主机密钥算法，已记录主机密钥时只协商已记录的密钥类型
+// known : known_hosts中已记录的密钥类型，为空表示未记录
+func (profile AlgorithmProfile) hostKeyAlgorithms(name string, known []string) ([]string, error) {
+	if len(known) == 0 {
+		return profile.HostKeyAlgorithms, nil
+	}
+	var algos []string
+	for _, algo := range known {
+		if contains(profile.HostKeyAlgorithms, algo) {
+			algos = append(algos, algo)
+		}
+	}
+	if len(algos) == 0 {
+		return nil, &CodeError{Code: CodeAlgorithmMismatch,
+			Err: fmt.Errorf("known host key types %s are not allowed by algorithm profile %s", strings.Join(known, ","), name)}
+	}
+	return algos, nil
+}
+
+// 判断列表中是否包含指定算法
+func contains(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiatedAlgorithms 与服务端协商出的算法
+type NegotiatedAlgorithms struct {
+	Profile            string `json:"profile"`            //使用的算法配置
+	KeyExchange        string `json:"kex"`                //密钥交换算法
+	HostKey            string `json:"hostKey"`            //主机密钥算法
+	CipherClientServer string `json:"cipherClientServer"` //客户端到服务端加密算法
+	CipherServerClient string `json:"cipherServerClient"` //服务端到客户端加密算法
+	MACClientServer    string `json:"macClientServer"`    //客户端到服务端消息认证算法
+	MACServerClient    string `json:"macServerClient"`    //服务端到客户端消息认证算法
+}
+
+// 记录双方KEXINIT报文的连接，ssh库没有提供协商结果，握手后由双方的算法列表计算
+type kexConn struct {
+	net.Conn                 //原始连接
+	mu       sync.Mutex      //保护两个方向的记录
+	client   kexInitRecorder //客户端发送的数据
+	server   kexInitRecorder //服务端发送的数据
+}
+
+// 记录一个方向上的版本行与第一个KEXINIT报文
+type kexInitRecorder struct {
+	buf   []byte   //已记录的数据
+	done  bool     //是否已经解析完成或放弃
+	lists []string //KEXINIT中的算法列表
+}
+
+// KEXINIT之前最多记录的字节数
+const maxKexInitRecord = 64 * 1024
+
+// Read 记录服务端发送的数据
+func (c *kexConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.server.record(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// Write 记录客户端发送的数据
+func (c *kexConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.client.record(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// negotiated 按RFC 4253的规则计算协商结果，取客户端列表中第一个服务端也支持的算法
+func (c *kexConn) negotiated(profile string) *NegotiatedAlgorithms {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client, server := c.client.lists, c.server.lists
+	if len(client) < 6 || len(server) < 6 {
+		return nil
+	}
+	pick := func(i int) string {
+		serverAlgos := strings.Split(server[i], ",")
+		for _, algo := range strings.Split(client[i], ",") {
+			if contains(serverAlgos, algo) {
+				return algo
+			}
+		}
+		return ""
+	}
+	result := &NegotiatedAlgorithms{
+		Profile:            profile,
+		KeyExchange:        pick(0),
+		HostKey:            pick(1),
+		CipherClientServer: pick(2),
+		CipherServerClient: pick(3),
+		MACClientServer:    pick(4),
+		MACServerClient:    pick(5),
+	}
+	//AEAD加密算法自带消息认证
+	if isAEAD(result.CipherClientServer) {
+		result.MACClientServer = "implicit"
+	}
+	if isAEAD(result.CipherServerClient) {
+		result.MACServerClient = "implicit"
+	}
+	return result
+}
+
+// 判断是否为AEAD加密算法
+func isAEAD(cipher string) bool {
+	return strings.HasSuffix(cipher, "-gcm@openssh.com") || cipher == "chacha20-poly1305@openssh.com"
+}
+
+// 追加数据并尝试解析KEXINIT
+func (r *kexInitRecorder) record(p []byte) {
+	if r.done || len(p) == 0 {
+		return
+	}
+	r.buf = append(r.buf, p...)
+	if len(r.buf) > maxKexInitRecord {
+		r.buf, r.done = nil, true
+		return
+	}
+	lists, ok := parseKexInit(r.buf)
+	if ok {
+		r.buf, r.done, r.lists = nil, true, lists
+	}
+}
+
+// 解析版本行之后的第一个报文，返回KEXINIT中的算法列表
+// 数据不完整时ok为false
+func parseKexInit(buf []byte) ([]string, bool) {
+	//跳过版本行及服务端在版本行之前发送的其他行
+	for {
+		end := bytes.IndexByte(buf, '\n')
+		if end < 0 {
+			return nil, false
+		}
+		line := buf[:end]
+		buf = buf[end+1:]
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+	//未加密报文: uint32长度, byte填充长度, 载荷, 填充
+	if len(buf) < 5 {
+		return nil, false
+	}
+	length := binary.BigEndian.Uint32(buf)
+	if uint64(len(buf)) < 4+uint64(length) {
+		return nil, false
+	}
+	padding := uint32(buf[4])
+	if length < padding+1 {
+		return nil, true
+	}
+	payload := buf[5 : 4+length-padding]
+	//消息类型20(KEXINIT)及16字节cookie
+	if len(payload) < 17 || payload[0] != 20 {
+		return nil, true
+	}
+	payload = payload[17:]
+	lists := make([]string, 0, 10)
+	for len(lists) < 10 {
+		if len(payload) < 4 {
+			return nil, true
+		}
+		size := binary.BigEndian.Uint32(payload)
+		if uint64(len(payload)) < 4+uint64(size) {
+			return nil, true
+		}
+		lists = append(lists, string(payload[4:4+size]))
+		payload = payload[4+size:]
+	}
+	return lists, true
+}
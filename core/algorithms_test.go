@@ -0,0 +1,54 @@
+// Package core : 核心包
+package core
+
+import (
+	"encoding/binary" //报文长度
+	"reflect"         //比较算法列表
+	"testing"         //测试
+)
+
+// 生成版本行及未加密的KEXINIT报文
+func kexInitPacket(version string, msgType byte, lists []string, padding int) []byte {
+	payload := append([]byte{msgType}, make([]byte, 16)...) //消息类型及cookie
+	for _, list := range lists {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(list)))
+		payload = append(payload, list...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0) //first_kex_packet_follows及保留字段
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	return append([]byte(version), packet...)
+}
+
+func TestParseKexInit(t *testing.T) {
+	lists := []string{
+		"curve25519-sha256,ecdh-sha2-nistp256", "ssh-ed25519,rsa-sha2-256",
+		"aes128-ctr", "aes128-ctr", "hmac-sha2-256", "hmac-sha2-256", "none", "none", "", "",
+	}
+	packet := kexInitPacket("SSH-2.0-OpenSSH_9.6\r\n", 20, lists, 4)
+	tests := []struct {
+		name  string
+		data  []byte
+		lists []string
+		ok    bool
+	}{
+		{"complete", packet, lists, true},
+		{"banner before version", append([]byte("welcome\r\nnotice\r\n"), packet...), lists, true},
+		{"partial version line", packet[:10], nil, false},
+		{"partial packet", packet[:len(packet)-6], nil, false},
+		{"only length", packet[:len("SSH-2.0-OpenSSH_9.6\r\n")+3], nil, false},
+		{"not kexinit", kexInitPacket("SSH-2.0-x\n", 21, lists, 4), nil, true},
+		{"too few lists", kexInitPacket("SSH-2.0-x\n", 20, lists[:3], 0), nil, true},
+		{"padding exceeds length", append([]byte("SSH-2.0-x\n"), 0, 0, 0, 2, 9, 0), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseKexInit(tt.data)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.lists) {
+				t.Fatalf("got %q, %v; want %q, %v", got, ok, tt.lists, tt.ok)
+			}
+		})
+	}
+}
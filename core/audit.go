@@ -0,0 +1,301 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"                   //webhook请求体
+	"crypto/sha256"           //会话ID
+	"encoding/hex"            //会话ID编码
+	"encoding/json"           //审计事件编码
+	"errors"                  //错误处理
+	"fmt"                     //格式化
+	"golang.org/x/crypto/ssh" //ssh库
+	"log"                     //日志库
+	"net/http"                //webhook
+	"os"                      //审计文件
+	"regexp"                  //密码提示匹配
+	"strconv"                 //端口格式化
+	"strings"                 //字符串库
+	"sync"                    //互斥锁
+	"time"                    //时间日期库
+	"unicode/utf8"            //输入解码
+)
+
+// 审计事件类型
+const (
+	AuditLogin         = "login"          //认证成功，键盘交互认证的会话在第一次打开终端完成认证时记录
+	AuditLoginFailed   = "login_failed"   //认证失败
+	AuditSessionEnd    = "session_end"    //会话关闭、过期或ssh连接断开
+	AuditTerminalOpen  = "terminal_open"  //打开终端
+	AuditTerminalClose = "terminal_close" //终端结束
+	AuditTerminalJoin  = "terminal_join"  //通过邀请链接连接共享的终端
+	AuditCommand       = "command"        //根据终端输入还原的命令行
+	AuditExec          = "exec"           //非交互执行的命令
+	AuditUpload        = "upload"         //上传文件
+	AuditDownload      = "download"       //下载文件
+	AuditTunnelOpen    = "tunnel_open"    //打开端口转发隧道
+	AuditTunnelClose   = "tunnel_close"   //关闭端口转发隧道
+)
+
+// AuditEvent 审计事件，每个事件写成一行JSON
+type AuditEvent struct {
+	Time       time.Time `json:"time"`                 //事件时间
+	Event      string    `json:"event"`                //事件类型
+	WebUser    string    `json:"webUser"`              //webssh用户
+	ClientIP   string    `json:"clientIP"`             //浏览器IP
+	Session    string    `json:"session,omitempty"`    //会话ID，由会话令牌计算，不记录令牌本身
+	Terminal   string    `json:"terminal,omitempty"`   //终端ID
+	Tunnel     string    `json:"tunnel,omitempty"`     //隧道ID
+	Target     string    `json:"target,omitempty"`     //隧道目标地址
+	Username   string    `json:"username,omitempty"`   //ssh登录用户
+	Host       string    `json:"host,omitempty"`       //目标主机
+	Port       int       `json:"port,omitempty"`       //目标端口
+	AuthMethod string    `json:"authMethod,omitempty"` //认证方式
+	JumpHosts  []string  `json:"jumpHosts,omitempty"`  //跳板机，用户@主机:端口
+	Actor      string    `json:"actor,omitempty"`      //共享终端中实际操作的观看者
+	Role       string    `json:"role,omitempty"`       //共享终端中的角色
+	Command    string    `json:"command,omitempty"`    //命令行
+	Path       string    `json:"path,omitempty"`       //文件路径
+	Size       int64     `json:"size,omitempty"`       //文件大小
+	Reason     string    `json:"reason,omitempty"`     //结束原因
+	ExitStatus *int      `json:"exitStatus,omitempty"` //shell退出码
+	ExitSignal string    `json:"exitSignal,omitempty"` //shell被信号终止时的信号名
+	Error      string    `json:"error,omitempty"`      //错误信息
+}
+
+// AuditSink 审计日志输出
+type AuditSink interface {
+	Write(line []byte) error //写入一行JSON，不含换行符
+}
+
+// 已配置的审计日志输出
+var auditSinks []AuditSink
+
+// InitAudit 初始化审计日志
+// spec : 逗号分隔的输出，file:路径、syslog:(本机)、syslog:udp://主机:端口、http(s)://webhook地址
+func InitAudit(spec string) error {
+	var sinks []AuditSink
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		var sink AuditSink
+		var err error
+		switch {
+		case strings.HasPrefix(item, "file:"):
+			sink, err = newFileSink(strings.TrimPrefix(item, "file:"))
+		case strings.HasPrefix(item, "syslog:"):
+			sink, err = newSyslogSink(strings.TrimPrefix(item, "syslog:"))
+		case strings.HasPrefix(item, "http://"), strings.HasPrefix(item, "https://"):
+			sink = newWebhookSink(item)
+		default:
+			err = fmt.Errorf("unknown audit sink %q, want file:, syslog: or http(s)://", item)
+		}
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	auditSinks = sinks
+	return nil
+}
+
+// Audit 写入审计事件，未配置审计日志时忽略
+func Audit(event AuditEvent) {
+	if len(auditSinks) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, sink := range auditSinks {
+		if err := sink.Write(line); err != nil {
+			log.Println("audit:", err)
+		}
+	}
+}
+
+// NewAuditEvent 创建审计事件并填入web用户、浏览器IP及目标主机
+func NewAuditEvent(event string, sclient *SSHClient) AuditEvent {
+	audit := AuditEvent{
+		Event:      event,
+		WebUser:    sclient.WebUser,
+		ClientIP:   sclient.ClientIP,
+		Username:   sclient.Username,
+		Host:       sclient.IPAddress,
+		Port:       sclient.Port,
+		AuthMethod: authMethod(sclient),
+	}
+	for _, hop := range sclient.JumpHosts {
+		audit.JumpHosts = append(audit.JumpHosts, hop.Username+"@"+hop.IPAddress+":"+strconv.Itoa(hop.Port))
+	}
+	return audit
+}
+
+// SessionID 由会话令牌计算的会话ID，审计日志中用它关联同一会话的事件
+func SessionID(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// 认证方式名称
+func authMethod(sclient *SSHClient) string {
+	switch sclient.LoginType {
+	case LoginPrivateKey:
+		if sclient.Certificate != "" {
+			return "certificate"
+		}
+		return "publickey"
+	case LoginKeyboardInteractive:
+		return "keyboard-interactive"
+	case LoginCertificate:
+		return "ca-certificate"
+	}
+	return "password"
+}
+
+// 解析shell退出状态，未正常退出时返回空
+func exitStatus(err error) (*int, string) {
+	var exitErr *ssh.ExitError
+	if err == nil {
+		status := 0
+		return &status, ""
+	}
+	if errors.As(err, &exitErr) {
+		status := exitErr.ExitStatus()
+		return &status, exitErr.Signal()
+	}
+	return nil, ""
+}
+
+// 追加写入本地文件
+type fileSink struct {
+	mu   sync.Mutex //串行化写入
+	file *os.File   //审计文件
+}
+
+// 以追加方式打开审计文件
+func newFileSink(name string) (AuditSink, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+// Write 写入一行
+func (sink *fileSink) Write(line []byte) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err := sink.file.Write(append(line, '\n'))
+	return err
+}
+
+// 把事件POST到webhook，后台发送，队列满时丢弃并记录日志
+type webhookSink struct {
+	url   string      //webhook地址
+	queue chan []byte //待发送的事件
+}
+
+// 创建webhook输出并启动发送协程
+func newWebhookSink(url string) AuditSink {
+	sink := &webhookSink{url: url, queue: make(chan []byte, 1024)}
+	go sink.run()
+	return sink
+}
+
+// Write 事件放入发送队列，不阻塞终端及文件操作
+func (sink *webhookSink) Write(line []byte) error {
+	select {
+	case sink.queue <- append([]byte(nil), line...):
+		return nil
+	default:
+		return errors.New("webhook queue full, event dropped")
+	}
+}
+
+// 逐个发送事件
+func (sink *webhookSink) run() {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for line := range sink.queue {
+		resp, err := client.Post(sink.url, "application/json", bytes.NewReader(line))
+		if err != nil {
+			log.Println("audit webhook:", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Println("audit webhook:", resp.Status)
+		}
+	}
+}
+
+// 密码等不回显输入的提示，回车时终端输出以此结尾的输入不记录
+// pin及passcode必须是完整的单词，避免Mapping:、Spinning up:等输出之后的命令不被记录
+var secretPrompt = regexp.MustCompile(`(?i)(password|passphrase|\bpasscode\b|verification code|\bpin\b|密码|口令)[^\n]*[:：]\s*$`)
+
+// 根据终端输入还原命令行，只处理可见字符、退格、Ctrl+U、Ctrl+C及回车
+// 方向键、Tab补全及历史命令无法还原，记录的是尽力而为的结果
+type commandLine struct {
+	line    []rune //当前行
+	escape  int    //转义序列状态: 0普通, 1收到ESC, 2 CSI或SS3
+	partial []byte //不完整的UTF-8字符
+}
+
+// 处理一段输入，返回回车时完成的命令行
+// prompt : 回车时终端最近的输出，用于识别密码提示
+func (l *commandLine) feed(p []byte, prompt func() string) []string {
+	var commands []string
+	data := append(l.partial, p...)
+	l.partial = nil
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			l.partial = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		switch l.escape {
+		case 1:
+			if r == '[' || r == 'O' {
+				l.escape = 2
+			} else {
+				l.escape = 0
+			}
+			continue
+		case 2:
+			if r >= 0x40 && r <= 0x7e {
+				l.escape = 0
+			}
+			continue
+		}
+		switch r {
+		case 0x1b:
+			l.escape = 1
+		case '\r', '\n':
+			command := strings.TrimSpace(string(l.line))
+			l.line = l.line[:0]
+			if command != "" && !secretPrompt.MatchString(prompt()) {
+				commands = append(commands, command)
+			}
+		case 0x7f, '\b':
+			if len(l.line) > 0 {
+				l.line = l.line[:len(l.line)-1]
+			}
+		case 0x15, 0x03: //Ctrl+U、Ctrl+C
+			l.line = l.line[:0]
+		default:
+			if r >= 0x20 {
+				l.line = append(l.line, r)
+			}
+		}
+	}
+	return commands
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+// Package core : 核心包
+package core
+
+import "errors" //错误处理
+
+// windows及plan9没有syslog
+func newSyslogSink(addr string) (AuditSink, error) {
+	return nil, errors.New("syslog audit sink is not supported on this platform")
+}
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+
+// Package core : 核心包
+package core
+
+import (
+	"fmt"        //格式化
+	"log/syslog" //系统日志
+	"net/url"    //解析syslog地址
+)
+
+// 写入syslog，facility为auth
+type syslogSink struct {
+	writer *syslog.Writer //syslog连接
+}
+
+// 连接syslog
+// addr : 为空时连接本机syslog，否则为udp://主机:端口或tcp://主机:端口
+func newSyslogSink(addr string) (AuditSink, error) {
+	network, raddr := "", ""
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q, want udp://host:port or tcp://host:port", addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_AUTH, "webssh")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+// Write 写入一行
+func (sink *syslogSink) Write(line []byte) error {
+	return sink.writer.Info(string(line))
+}
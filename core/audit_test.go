@@ -0,0 +1,64 @@
+// Package core : 核心包
+package core
+
+import (
+	"reflect" //比较命令列表
+	"testing" //测试
+)
+
+func TestSecretPrompt(t *testing.T) {
+	tests := []struct {
+		prompt string
+		secret bool
+	}{
+		{"[sudo] password for root: ", true},
+		{"Enter passphrase for key '/root/.ssh/id_ed25519': ", true},
+		{"Enter PIN for 'token': ", true},
+		{"PIN:", true},
+		{"Passcode: ", true},
+		{"Verification code: ", true},
+		{"请输入密码：", true},
+		{"$ ", false},
+		{"Mapping:", false},
+		{"Spinning up:", false},
+		{"Shipping: ", false},
+		{"passcodes left: ", false},
+		{"password: \r\nroot@host:~# ", false},
+	}
+	for _, tt := range tests {
+		if got := secretPrompt.MatchString(tt.prompt); got != tt.secret {
+			t.Errorf("secretPrompt.MatchString(%q) = %v, want %v", tt.prompt, got, tt.secret)
+		}
+	}
+}
+
+func TestCommandLineFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		prompt string
+		want   []string
+	}{
+		{"simple", []string{"ls -l\r"}, "$ ", []string{"ls -l"}},
+		{"split across chunks", []string{"echo ", "hi\r", "pwd\r"}, "$ ", []string{"echo hi", "pwd"}},
+		{"backspace", []string{"lss\x7f -a\r"}, "$ ", []string{"ls -a"}},
+		{"ctrl-u and ctrl-c", []string{"rm -rf /\x15ls\r", "oops\x03\r"}, "$ ", []string{"ls"}},
+		{"escape sequences ignored", []string{"l\x1b[Ds\x1bOA\r"}, "$ ", []string{"ls"}},
+		{"empty lines skipped", []string{"\r  \r"}, "$ ", nil},
+		{"utf-8 split across chunks", []string{"echo \xe4\xb8", "\xad\r"}, "$ ", []string{"echo 中"}},
+		{"password not recorded", []string{"hunter2\r"}, "[sudo] password for u: ", nil},
+		{"word containing pin recorded", []string{"yes\r"}, "Spinning up: ", []string{"yes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l commandLine
+			var got []string
+			for _, chunk := range tt.chunks {
+				got = append(got, l.feed([]byte(chunk), func() string { return tt.prompt })...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
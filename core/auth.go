@@ -0,0 +1,185 @@
+// Package core : 核心包
+package core
+
+import (
+	"errors"                  //错误处理
+	"fmt"                     //格式化
+	"golang.org/x/crypto/ssh" //ssh库
+	"strings"                 //字符串库
+	"time"                    //时间日期库
+)
+
+// 登录类型，对应sshInfo中的logintype字段
+const (
+	LoginPassword            = 0 //密码登录，服务端要求时自动回退到键盘交互认证
+	LoginPrivateKey          = 1 //私钥登录
+	LoginKeyboardInteractive = 2 //键盘交互认证(OTP、RADIUS、密码过期修改等)
+	LoginCertificate         = 3 //内置CA为web用户签发临时证书登录
+)
+
+// 键盘交互认证相关错误码
+const (
+	CodeInteractiveRequired = "INTERACTIVE_REQUIRED" //需要键盘交互认证，但当前请求无法转发问题
+	CodeAuthTimeout         = "AUTH_TIMEOUT"         //等待浏览器回答超时
+	CodeAuthCanceled        = "AUTH_CANCELED"        //用户取消认证
+)
+
+// ChallengeTimeout 等待浏览器回答键盘交互问题的超时时间
+var ChallengeTimeout = time.Minute
+
+// authMethods 按登录类型生成SSH认证方法
+func (sclient *SSHClient) authMethods() ([]ssh.AuthMethod, error) {
+	auth := make([]ssh.AuthMethod, 0) //分配授权方法内存
+	switch sclient.LoginType {
+	case LoginPrivateKey:
+		//取私有密钥签名，支持加密私钥及PuTTY格式私钥
+		signer, err := ParsePrivateKey(sclient.Password, sclient.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		//提供了证书时使用证书认证
+		if sclient.Certificate != "" {
+			if signer, err = certSigner(signer, sclient.Certificate); err != nil {
+				return nil, err
+			}
+		}
+		auth = append(auth, ssh.PublicKeys(signer)) //使用SSH公钥
+		//服务端要求公钥之后再回答验证码(AuthenticationMethods publickey,keyboard-interactive)
+		auth = append(auth, ssh.KeyboardInteractive(sclient.keyboardInteractive()))
+	case LoginCertificate:
+		if CA == nil {
+			return nil, &CodeError{Code: CodeCAUnavailable, Err: errors.New("certificate authority is not configured")}
+		}
+		signer, err := CA.Issue(sclient.WebUser, sclient.Username)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+		auth = append(auth, ssh.KeyboardInteractive(sclient.keyboardInteractive()))
+	case LoginKeyboardInteractive:
+		auth = append(auth, ssh.KeyboardInteractive(sclient.keyboardInteractive()))
+	default:
+		auth = append(auth, ssh.Password(sclient.Password)) //添加SSH密码
+		//服务端禁用password方式时回退到键盘交互认证
+		auth = append(auth, ssh.KeyboardInteractive(sclient.keyboardInteractive()))
+	}
+	return auth, nil
+}
+
+// keyboardInteractive 键盘交互认证回调
+// 第一个密码问题自动使用已填写的密码回答，其余问题(验证码、新密码等)转发给浏览器
+// 私钥及证书登录时Password为私钥内容，全部问题都转发给浏览器
+// 自动回答过的密码问题再次出现说明密码错误，直接返回认证失败，不转发给浏览器变成待认证的会话
+func (sclient *SSHClient) keyboardInteractive() ssh.KeyboardInteractiveChallenge {
+	passwordUsed := sclient.Password == "" || sclient.LoginType == LoginPrivateKey || sclient.LoginType == LoginCertificate
+	answered := "" //自动回答过的密码问题
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		var (
+			relayIndex []int    //需要转发的问题序号
+			relayQ     []string //需要转发的问题
+			relayEchos []bool   //需要转发的问题是否回显
+		)
+		for i, question := range questions {
+			if answered != "" && strings.TrimSpace(question) == answered {
+				return nil, errors.New("keyboard-interactive: password rejected by server")
+			}
+			if !passwordUsed && !echos[i] && strings.Contains(strings.ToLower(question), "password") {
+				answers[i] = sclient.Password
+				passwordUsed, answered = true, strings.TrimSpace(question)
+				continue
+			}
+			relayIndex = append(relayIndex, i)
+			relayQ = append(relayQ, question)
+			relayEchos = append(relayEchos, echos[i])
+		}
+		if len(relayQ) == 0 {
+			return answers, nil
+		}
+		if sclient.Challenge == nil {
+			return nil, &CodeError{Code: CodeInteractiveRequired,
+				Err: fmt.Errorf("server requires keyboard-interactive authentication: %s", strings.Join(relayQ, " "))}
+		}
+		relayed, err := sclient.Challenge(name, instruction, relayQ, relayEchos)
+		if err != nil {
+			return nil, err
+		}
+		if len(relayed) != len(relayQ) {
+			return nil, errors.New("keyboard-interactive: answer count mismatch")
+		}
+		for i, index := range relayIndex {
+			answers[index] = relayed[i]
+		}
+		return answers, nil
+	}
+}
+
+// WsChallenge 通过终端websocket向浏览器转发键盘交互问题，并读取用户在终端中输入的回答
+// ws : 终端websocket连接
+func WsChallenge(ws *TermConn) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		defer ws.SetReadDeadline(time.Time{}) //恢复读超时
+		//显示认证名称与说明
+		for _, text := range []string{name, instruction} {
+			if text = strings.TrimSpace(text); text != "" {
+				ws.Output([]byte(strings.ReplaceAll(text, "\n", "\r\n") + "\r\n"))
+			}
+		}
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			ws.Output([]byte(question))
+			answer, err := readAnswer(ws, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
+
+// 从websocket读取一行回答，支持退格，Ctrl+C取消
+// echo : 是否回显输入的字符
+func readAnswer(ws *TermConn, echo bool) (string, error) {
+	var answer []rune
+	ws.SetReadDeadline(time.Now().Add(ChallengeTimeout))
+	for {
+		msg, err := ws.Read()
+		if err != nil {
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return "", &CodeError{Code: CodeAuthTimeout, Err: errors.New("keyboard-interactive: timed out waiting for answer")}
+			}
+			return "", err
+		}
+		//忽略心跳、窗口大小调整及信号
+		if msg.Type != MessageInput {
+			if msg.Type == MessagePing {
+				ws.Send(ControlMessage{Type: MessagePong})
+			}
+			continue
+		}
+		for _, r := range msg.Data {
+			switch r {
+			case '\r', '\n': //回车结束输入
+				ws.Output([]byte("\r\n"))
+				return string(answer), nil
+			case 0x03: //Ctrl+C取消
+				ws.Output([]byte("^C\r\n"))
+				return "", &CodeError{Code: CodeAuthCanceled, Err: errors.New("keyboard-interactive: canceled by user")}
+			case 0x7f, '\b': //退格
+				if len(answer) > 0 {
+					answer = answer[:len(answer)-1]
+					if echo {
+						ws.Output([]byte("\b \b"))
+					}
+				}
+			default:
+				answer = append(answer, r)
+				if echo {
+					ws.Output([]byte(string(r)))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package core : 核心包
+package core
+
+import (
+	"golang.org/x/crypto/ssh" //ssh库
+	"testing"                 //测试
+)
+
+// 按PAM的方式询问密码，密码错误时重新询问，密码正确后再询问验证码
+func pamServerConfig() *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			for try := 0; try < 3; try++ {
+				answers, err := client("", "", []string{"Password: "}, []bool{false})
+				if err != nil {
+					return nil, err
+				}
+				if len(answers) == 1 && answers[0] == "pass" {
+					answers, err = client("", "", []string{"Verification code: "}, []bool{true})
+					if err != nil {
+						return nil, err
+					}
+					if len(answers) == 1 && answers[0] == "123456" {
+						return nil, nil
+					}
+					return nil, ssh.ErrNoAuth
+				}
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+}
+
+func TestKeyboardInteractivePassword(t *testing.T) {
+	acceptAllHostKeys(t)
+	host, port, _ := startTestSSHServer(t, pamServerConfig())
+	otp := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{"123456"}, nil
+	}
+	tests := []struct {
+		name      string
+		password  string
+		challenge ssh.KeyboardInteractiveChallenge
+		ok        bool
+		code      string //失败时的错误码
+	}{
+		{"wrong password fails", "wrong", nil, false, ""},
+		{"wrong password fails with challenge", "wrong", otp, false, ""},
+		{"otp needs terminal", "pass", nil, false, CodeInteractiveRequired},
+		{"otp relayed", "pass", otp, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sclient := &SSHClient{Username: "u", IPAddress: host, Port: port, Password: tt.password, Challenge: tt.challenge}
+			err := sclient.GenerateClient()
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				sclient.Close()
+				return
+			}
+			if err == nil {
+				sclient.Close()
+				t.Fatal("login succeeded")
+			}
+			if ErrorCode(err) != tt.code {
+				t.Fatalf("got %v (code %q), want code %q", err, ErrorCode(err), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// Package core : 核心包
+package core
+
+import (
+	"encoding/json" //主机组及请求解码
+	"errors"        //错误处理
+	"fmt"           //格式化
+	"net"           //主机:端口解析
+	"os"            //读取主机组文件
+	"strconv"       //端口解析
+	"strings"       //字符串库
+	"sync"          //并发控制
+	"time"          //时间日期库
+)
+
+// BatchConcurrency 批量执行同时连接的最大主机数
+var BatchConcurrency = 20
+
+// 主机组，组名到主机列表的映射
+var hostGroups map[string][]BatchHost
+
+// InitHostGroups 读取主机组文件，文件为组名到主机列表的JSON对象
+// 主机可以是sshInfo格式的对象，也可以是用户@主机:端口字符串
+// file : 主机组文件路径，为空时不使用主机组
+func InitHostGroups(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	groups := make(map[string][]BatchHost)
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return fmt.Errorf("invalid host group file %s: %v", file, err)
+	}
+	hostGroups = groups
+	return nil
+}
+
+// HostGroups 返回主机组名及主机数
+func HostGroups() map[string]int {
+	groups := make(map[string]int, len(hostGroups))
+	for name, hosts := range hostGroups {
+		groups[name] = len(hosts)
+	}
+	return groups
+}
+
+// BatchHost 批量执行的目标主机，未填写的认证信息使用请求中的auth
+type BatchHost struct {
+	SSHClient
+}
+
+// UnmarshalJSON 支持sshInfo格式的对象及用户@主机:端口字符串
+func (host *BatchHost) UnmarshalJSON(data []byte) error {
+	var spec string
+	if err := json.Unmarshal(data, &spec); err == nil {
+		return host.parse(spec)
+	}
+	return json.Unmarshal(data, &host.SSHClient)
+}
+
+// 解析用户@主机:端口，用户及端口可以省略
+func (host *BatchHost) parse(spec string) error {
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		host.Username, spec = spec[:i], spec[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(spec); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("invalid host %q: %v", spec, err)
+		}
+		host.IPAddress, host.Port = h, port
+	} else {
+		host.IPAddress = strings.Trim(spec, "[]")
+	}
+	if host.IPAddress == "" {
+		return fmt.Errorf("invalid host %q", spec)
+	}
+	return nil
+}
+
+// 显示名称，用户@主机:端口
+func (host *BatchHost) name() string {
+	return host.Username + "@" + host.IPAddress + ":" + strconv.Itoa(host.Port)
+}
+
+// BatchRequest 批量执行请求
+type BatchRequest struct {
+	Hosts       []BatchHost `json:"hosts"`       //目标主机
+	Group       string      `json:"group"`       //主机组名，与hosts合并
+	Auth        SSHClient   `json:"auth"`        //主机未填写密码时使用的用户名及认证信息、跳板机、代理、算法及字符编码
+	Command     string      `json:"command"`     //命令
+	Concurrency int         `json:"concurrency"` //同时连接的主机数，为0或超过BatchConcurrency时使用BatchConcurrency
+	Timeout     int         `json:"timeout"`     //每台主机的命令超时时间(s)
+}
+
+// BatchResult 单台主机的执行结果
+type BatchResult struct {
+	Index  int         `json:"index"`            //主机在请求中的序号
+	Host   string      `json:"host"`             //用户@主机:端口
+	Result *ExecResult `json:"result,omitempty"` //执行结果，连接或认证失败时为空
+	Error  string      `json:"error,omitempty"`  //错误信息
+	Code   string      `json:"code,omitempty"`   //错误码
+}
+
+// BatchSummary 批量执行汇总
+type BatchSummary struct {
+	Total     int            `json:"total"`     //主机数
+	Succeeded int            `json:"succeeded"` //退出码为0的主机数
+	Failed    int            `json:"failed"`    //退出码非0、被信号终止或超时的主机数
+	Errors    int            `json:"errors"`    //连接、认证或执行失败的主机数
+	Skipped   int            `json:"skipped"`   //请求取消后未执行的主机数
+	ExitCodes map[string]int `json:"exitCodes"` //按退出码统计的主机数，被信号终止时为信号名，超时为timeout
+	Duration  float64        `json:"duration"`  //总耗时(s)
+}
+
+// Resolve 校验请求，合并主机组及主机列表并补全未填写的认证信息
+func (req *BatchRequest) Resolve() ([]BatchHost, error) {
+	if req.Command == "" {
+		return nil, errors.New("command is required")
+	}
+	hosts := append([]BatchHost(nil), req.Hosts...)
+	if req.Group != "" {
+		group, ok := hostGroups[req.Group]
+		if !ok {
+			return nil, fmt.Errorf("host group %q not found", req.Group)
+		}
+		hosts = append(hosts, group...)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts")
+	}
+	for i := range hosts {
+		host := &hosts[i]
+		if host.Password == "" {
+			host.LoginType, host.Password = req.Auth.LoginType, req.Auth.Password
+			host.Passphrase, host.Certificate = req.Auth.Passphrase, req.Auth.Certificate
+		}
+		if host.Username == "" {
+			host.Username = req.Auth.Username
+		}
+		if host.Username == "" {
+			host.Username = "root"
+		}
+		if host.Port == 0 {
+			host.Port = 22
+		}
+		//跳板机切片可能来自主机组，复制后再补全，避免并发请求修改共享的配置
+		if len(host.JumpHosts) == 0 {
+			host.JumpHosts = append([]SSHClient(nil), req.Auth.JumpHosts...)
+		} else {
+			host.JumpHosts = append([]SSHClient(nil), host.JumpHosts...)
+		}
+		if host.Proxy == "" {
+			host.Proxy = req.Auth.Proxy
+		}
+		if host.Algorithms == "" {
+			host.Algorithms = req.Auth.Algorithms
+		}
+		if host.Encoding == "" {
+			host.Encoding = req.Auth.Encoding
+		}
+		if _, err := lookupEncoding(host.Encoding); err != nil {
+			return nil, fmt.Errorf("host %s: %v", host.name(), err)
+		}
+		host.IPAddress = normalizeIPAddress(host.IPAddress)
+		for j := range host.JumpHosts {
+			hop := &host.JumpHosts[j]
+			hop.IPAddress = normalizeIPAddress(hop.IPAddress)
+			if hop.Username == "" {
+				hop.Username = "root"
+			}
+			if hop.Port == 0 {
+				hop.Port = 22
+			}
+			hop.JumpHosts = nil
+		}
+	}
+	return hosts, nil
+}
+
+// RunBatch 在多台主机上并发执行命令，每台主机完成后调用onResult，返回汇总
+// hosts : 目标主机，见Resolve
+// webUser, clientIP : 当前web用户及浏览器IP，用于内置CA签发证书及审计日志
+// stop : 关闭后不再连接新的主机，正在执行的命令继续到结束或超时
+// onResult : 单台主机的结果，串行调用
+func (req *BatchRequest) RunBatch(hosts []BatchHost, webUser, clientIP string, stop <-chan struct{}, onResult func(BatchResult)) BatchSummary {
+	start := time.Now()
+	concurrency := req.Concurrency
+	if concurrency <= 0 || concurrency > BatchConcurrency {
+		concurrency = BatchConcurrency
+	}
+	summary := BatchSummary{Total: len(hosts), ExitCodes: make(map[string]int)}
+	var mu sync.Mutex //保护summary并串行化onResult
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+loop:
+	for i := range hosts {
+		select {
+		case <-stop:
+			mu.Lock()
+			summary.Skipped = len(hosts) - i
+			mu.Unlock()
+			break loop
+		case slots <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(index int, host BatchHost) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := runBatchHost(index, host, req.Command, time.Duration(req.Timeout)*time.Second, webUser, clientIP)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case result.Result == nil:
+				summary.Errors++
+			case result.Result.TimedOut:
+				summary.Failed++
+				summary.ExitCodes["timeout"]++
+			case result.Result.ExitStatus == nil:
+				summary.Failed++
+				summary.ExitCodes[result.Result.ExitSignal]++
+			default:
+				if *result.Result.ExitStatus == 0 {
+					summary.Succeeded++
+				} else {
+					summary.Failed++
+				}
+				summary.ExitCodes[strconv.Itoa(*result.Result.ExitStatus)]++
+			}
+			onResult(result)
+		}(i, hosts[i])
+	}
+	wg.Wait()
+	summary.Duration = time.Since(start).Seconds()
+	return summary
+}
+
+// 连接单台主机执行命令并写入审计日志
+func runBatchHost(index int, host BatchHost, command string, timeout time.Duration, webUser, clientIP string) BatchResult {
+	sclient := host.SSHClient
+	sclient.WebUser, sclient.ClientIP = webUser, clientIP
+	result := BatchResult{Index: index, Host: host.name()}
+	audit := NewAuditEvent(AuditExec, &sclient)
+	audit.Command = command
+	defer func() { Audit(audit) }()
+	if err := sclient.GenerateClient(); err != nil {
+		result.Error, result.Code = err.Error(), ErrorCode(err)
+		audit.Error = err.Error()
+		return result
+	}
+	defer sclient.Close()
+	execResult, err := sclient.Exec(command, timeout)
+	if err != nil {
+		result.Error, result.Code = err.Error(), ErrorCode(err)
+		audit.Error = err.Error()
+		return result
+	}
+	result.Result = execResult
+	audit.ExitStatus, audit.ExitSignal = execResult.ExitStatus, execResult.ExitSignal
+	if execResult.TimedOut {
+		audit.Reason = "timeout"
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/ed25519"          //临时密钥
+	"crypto/rand"             //随机数
+	"encoding/binary"         //证书序列号
+	"errors"                  //错误处理
+	"fmt"                     //格式化
+	"golang.org/x/crypto/ssh" //ssh库
+	"os"                      //读取CA私钥
+	"strings"                 //字符串库
+	"time"                    //时间日期库
+)
+
+// 证书相关错误码
+const (
+	CodeCertInvalid   = "CERT_INVALID"   //无法解析或与私钥不匹配的证书
+	CodeCAUnavailable = "CA_UNAVAILABLE" //未配置内置CA或无法确定web用户
+)
+
+// CertAuthority 内置的用户证书签发CA
+// 每次登录为web用户生成临时密钥并签发短期证书，浏览器中不需要粘贴任何长期有效的私钥
+type CertAuthority struct {
+	signer     ssh.Signer    //CA私钥
+	principals []string      //证书principal模板，{user}为web用户，{username}为ssh登录用户名
+	validity   time.Duration //证书有效期
+}
+
+// CA 全局内置CA，未配置时为空
+var CA *CertAuthority
+
+// InitCertAuthority 初始化内置CA
+// keyPath : CA私钥文件路径
+// principals : 逗号分隔的principal模板
+// validity : 证书有效期
+func InitCertAuthority(keyPath, principals string, validity time.Duration) error {
+	content, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	signer, err := ParsePrivateKey(string(content), "")
+	if err != nil {
+		return fmt.Errorf("load CA key %s: %w", keyPath, err)
+	}
+	ca := &CertAuthority{signer: signer, validity: validity}
+	for _, principal := range strings.Split(principals, ",") {
+		if principal = strings.TrimSpace(principal); principal != "" {
+			ca.principals = append(ca.principals, principal)
+		}
+	}
+	if len(ca.principals) == 0 {
+		return errors.New("CA principals must not be empty")
+	}
+	CA = ca
+	return nil
+}
+
+// PublicKey 返回authorized_keys格式的CA公钥，用于配置服务端的TrustedUserCAKeys
+func (ca *CertAuthority) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey())))
+}
+
+// Issue 为web用户生成临时密钥并签发证书
+// webUser : 登录webssh的用户
+// username : ssh登录用户名
+func (ca *CertAuthority) Issue(webUser, username string) (ssh.Signer, error) {
+	if webUser == "" {
+		return nil, &CodeError{Code: CodeCAUnavailable, Err: errors.New("certificate login requires web authentication (-a)")}
+	}
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	//principal模板替换
+	replacer := strings.NewReplacer("{user}", webUser, "{username}", username)
+	principals := make([]string, 0, len(ca.principals))
+	for _, principal := range ca.principals {
+		principals = append(principals, replacer.Replace(principal))
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             sshPublicKey,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           "webssh:" + webUser,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-5 * time.Minute).Unix()), //容忍服务器时钟偏差
+		ValidBefore:     uint64(now.Add(ca.validity).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":             "",
+				"permit-port-forwarding": "",
+				"permit-user-rc":         "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, err
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// 用证书包装私钥签名器
+// certificate : OpenSSH格式证书(xxx-cert.pub内容)
+func certSigner(signer ssh.Signer, certificate string) (ssh.Signer, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, &CodeError{Code: CodeCertInvalid, Err: err}
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, &CodeError{Code: CodeCertInvalid, Err: errors.New("not an OpenSSH certificate")}
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, &CodeError{Code: CodeCertInvalid, Err: errors.New("not a user certificate")}
+	}
+	//提前检查有效期，给出比服务端认证失败更明确的提示
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter || (cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore) {
+		return nil, &CodeError{Code: CodeCertInvalid, Err: fmt.Errorf("certificate %s is expired or not yet valid", cert.KeyId)}
+	}
+	wrapped, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, &CodeError{Code: CodeCertInvalid, Err: err}
+	}
+	return wrapped, nil
+}
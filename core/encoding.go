@@ -0,0 +1,108 @@
+// Package core : 核心包
+package core
+
+import (
+	"errors"                                        //错误处理
+	"fmt"                                           //格式化
+	"golang.org/x/text/encoding"                    //字符编码
+	"golang.org/x/text/encoding/simplifiedchinese"  //GBK、GB18030
+	"golang.org/x/text/encoding/traditionalchinese" //Big5
+	"golang.org/x/text/transform"                   //流式转换
+	"strings"                                       //字符串库
+)
+
+// 支持的远程主机字符编码，utf-8及空值不转换
+var encodings = map[string]encoding.Encoding{
+	"gbk":     simplifiedchinese.GBK,
+	"gb18030": simplifiedchinese.GB18030,
+	"big5":    traditionalchinese.Big5,
+}
+
+// 按名称取字符编码，utf-8或为空时返回nil
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "utf-8" || name == "utf8" {
+		return nil, nil
+	}
+	if enc, ok := encodings[name]; ok {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q, want utf-8, gbk, gb18030 or big5", name)
+}
+
+// 远程主机的字符编码，utf-8时返回nil
+func (sclient *SSHClient) charset() encoding.Encoding {
+	enc, _ := lookupEncoding(sclient.Encoding)
+	return enc
+}
+
+// RemotePath 把浏览器提交的UTF-8路径转换为远程主机的编码，无法表示的字符返回错误
+func (sclient *SSHClient) RemotePath(path string) (string, error) {
+	enc := sclient.charset()
+	if enc == nil {
+		return path, nil
+	}
+	remote, err := enc.NewEncoder().String(path)
+	if err != nil {
+		return "", fmt.Errorf("path %q cannot be represented in %s", path, sclient.Encoding)
+	}
+	return remote, nil
+}
+
+// LocalName 把远程主机返回的文件名转换为UTF-8
+func (sclient *SSHClient) LocalName(name string) string {
+	enc := sclient.charset()
+	if enc == nil {
+		return name
+	}
+	local, err := enc.NewDecoder().String(name)
+	if err != nil {
+		return name
+	}
+	return local
+}
+
+// 流式转换，末尾不完整的字符保留到下一次转换，调用方负责串行化
+type transcoder struct {
+	t       transform.Transformer //解码器或编码器
+	partial []byte                //上一次末尾不完整的字符
+}
+
+// 解码远程输出为UTF-8，utf-8时返回nil
+func newDecoder(enc encoding.Encoding) *transcoder {
+	if enc == nil {
+		return nil
+	}
+	return &transcoder{t: enc.NewDecoder()}
+}
+
+// 编码浏览器输入为远程编码，无法表示的字符替换为编码的替换字符，utf-8时返回nil
+func newEncoder(enc encoding.Encoding) *transcoder {
+	if enc == nil {
+		return nil
+	}
+	return &transcoder{t: encoding.ReplaceUnsupported(enc.NewEncoder())}
+}
+
+// 转换p，返回已完整转换的部分
+func (tc *transcoder) convert(p []byte) []byte {
+	src := append(tc.partial, p...)
+	dst := make([]byte, len(src)*3+16) //解码最多为3倍，编码不会变长
+	var out []byte
+	for {
+		nDst, nSrc, err := tc.t.Transform(dst, src, false)
+		out = append(out, dst[:nDst]...)
+		src = src[nSrc:]
+		if errors.Is(err, transform.ErrShortDst) && nSrc > 0 {
+			continue
+		}
+		//ErrShortSrc时剩余的为不完整的字符，其他错误时丢弃无法转换的字节
+		if err != nil && !errors.Is(err, transform.ErrShortSrc) && len(src) > 0 {
+			src = src[1:]
+			continue
+		}
+		break
+	}
+	tc.partial = append(tc.partial[:0:0], src...)
+	return out
+}
@@ -0,0 +1,123 @@
+// Package core : 核心包
+package core
+
+import (
+	"fmt"     //格式化
+	"testing" //测试
+)
+
+func TestLookupEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantNil bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{"utf-8", true, false},
+		{" UTF8 ", true, false},
+		{"gbk", false, false},
+		{"GB18030", false, false},
+		{"big5", false, false},
+		{"latin1", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := lookupEncoding(tt.name)
+			if (err != nil) != tt.wantErr || (enc == nil) != tt.wantNil {
+				t.Fatalf("lookupEncoding(%q) = %v, %v", tt.name, enc, err)
+			}
+		})
+	}
+}
+
+// 按chunks依次转换，返回全部输出
+func convertChunks(tc *transcoder, chunks [][]byte) string {
+	var out []byte
+	for _, chunk := range chunks {
+		out = append(out, tc.convert(chunk)...)
+	}
+	return string(out)
+}
+
+func TestDecoderSplit(t *testing.T) {
+	tests := []struct {
+		encoding string
+		remote   string //远程编码的输出
+		want     string
+	}{
+		{"gbk", "ls\xd6\xd0\xce\xc4\r\n", "ls中文\r\n"},
+		{"gb18030", "a\x81\x30\x81\x30b", "a\u0080b"}, //四字节字符
+		{"big5", "\xa4\xa4\xa4\xe5", "中文"},
+	}
+	for _, tt := range tests {
+		enc, _ := lookupEncoding(tt.encoding)
+		remote := []byte(tt.remote)
+		t.Run(tt.encoding+" whole", func(t *testing.T) {
+			if got := convertChunks(newDecoder(enc), [][]byte{remote}); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+		t.Run(tt.encoding+" byte by byte", func(t *testing.T) {
+			var chunks [][]byte
+			for i := range remote {
+				chunks = append(chunks, remote[i:i+1])
+			}
+			if got := convertChunks(newDecoder(enc), chunks); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+		//在每个字节位置拆分为两次输出，不完整的字符保留到下一次
+		for i := 1; i < len(remote); i++ {
+			t.Run(fmt.Sprintf("%s split at %d", tt.encoding, i), func(t *testing.T) {
+				if got := convertChunks(newDecoder(enc), [][]byte{remote[:i], remote[i:]}); got != tt.want {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecoderPartial(t *testing.T) {
+	enc, _ := lookupEncoding("gbk")
+	tc := newDecoder(enc)
+	if got := tc.convert([]byte("a\xd6")); string(got) != "a" {
+		t.Fatalf("got %q, want %q", got, "a")
+	}
+	if string(tc.partial) != "\xd6" {
+		t.Fatalf("partial %q, want %q", tc.partial, "\xd6")
+	}
+	if got := tc.convert([]byte("\xd0")); string(got) != "中" {
+		t.Fatalf("got %q, want %q", got, "中")
+	}
+	if len(tc.partial) != 0 {
+		t.Fatalf("partial %q left", tc.partial)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	enc, _ := lookupEncoding("gbk")
+	if newEncoder(nil) != nil || newDecoder(nil) != nil {
+		t.Fatal("utf-8 should not transcode")
+	}
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"ascii", []string{"ls -l\r"}, "ls -l\r"},
+		{"chinese", []string{"cd 中文\r"}, "cd \xd6\xd0\xce\xc4\r"},
+		{"split utf-8", []string{"\xe4\xb8", "\xad"}, "\xd6\xd0"},
+		{"unsupported replaced", []string{"a😀b"}, "a\x1ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks [][]byte
+			for _, chunk := range tt.chunks {
+				chunks = append(chunks, []byte(chunk))
+			}
+			if got := convertChunks(newEncoder(enc), chunks); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Package core : 核心包
+package core
+
+import "errors" //错误处理
+
+// 错误码常量，随响应返回给浏览器，用于区分不同的失败原因
+const (
+	CodeHostKeyUnknown   = "HOSTKEY_UNKNOWN"   //主机密钥未知(严格模式下拒绝)
+	CodeHostKeyMismatch  = "HOSTKEY_MISMATCH"  //主机密钥与已记录的不一致
+	CodeHostKeyForbidden = "HOSTKEY_FORBIDDEN" //当前web用户不能删除主机密钥记录
+)
+
+// CodeError 带错误码的错误
+type CodeError struct {
+	Code string //错误码
+	Err  error  //原始错误
+}
+
+// Error 实现error接口
+func (e *CodeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap 返回原始错误，支持errors.Is/errors.As
+func (e *CodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode 取错误链中的错误码，没有错误码时返回空字符串
+func ErrorCode(err error) string {
+	var codeErr *CodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+// Package core : 核心包
+package core
+
+import (
+	"errors"                     //错误处理
+	"fmt"                        //格式化
+	"golang.org/x/crypto/ssh"    //ssh库
+	"golang.org/x/text/encoding" //字符编码
+	"time"                       //时间日期库
+)
+
+// 非交互命令执行配置
+var (
+	ExecMaxOutput  int64 = 1024 * 1024      //stdout及stderr各自保留的最大字节数，超出部分丢弃
+	ExecTimeout          = time.Minute      //未指定超时时的默认值
+	ExecMaxTimeout       = 10 * time.Minute //允许指定的最大超时
+)
+
+// ExecResult 命令执行结果
+type ExecResult struct {
+	Stdout          string  `json:"stdout"`               //标准输出
+	Stderr          string  `json:"stderr"`               //标准错误
+	StdoutTruncated bool    `json:"stdoutTruncated"`      //标准输出是否超过大小限制被截断
+	StderrTruncated bool    `json:"stderrTruncated"`      //标准错误是否超过大小限制被截断
+	ExitStatus      *int    `json:"exitStatus"`           //退出码，被信号终止或超时时为空
+	ExitSignal      string  `json:"exitSignal,omitempty"` //被信号终止时的信号名
+	TimedOut        bool    `json:"timedOut"`             //是否超时被终止
+	Duration        float64 `json:"duration"`             //执行时间(s)
+}
+
+// Exec 不分配pty执行命令，stdout及stderr分别返回
+// 超时后向远程进程发送KILL信号并关闭通道，服务端不支持信号时由关闭通道结束进程
+// command : 命令
+// timeout : 超时时间，为0时使用ExecTimeout，超过ExecMaxTimeout时使用ExecMaxTimeout
+func (sclient *SSHClient) Exec(command string, timeout time.Duration) (*ExecResult, error) {
+	if command == "" {
+		return nil, errors.New("command is required")
+	}
+	if timeout <= 0 {
+		timeout = ExecTimeout
+	}
+	if timeout > ExecMaxTimeout {
+		timeout = ExecMaxTimeout
+	}
+	//远程不是utf-8时转换命令及输出
+	enc := sclient.charset()
+	if enc != nil {
+		remote, err := enc.NewEncoder().String(command)
+		if err != nil {
+			return nil, fmt.Errorf("command cannot be represented in %s", sclient.Encoding)
+		}
+		command = remote
+	}
+	session, err := sclient.Client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	stdout := &limitedBuffer{limit: ExecMaxOutput}
+	stderr := &limitedBuffer{limit: ExecMaxOutput}
+	session.Stdout, session.Stderr = stdout, stderr
+	start := time.Now()
+	if err := session.Start(command); err != nil {
+		return nil, err
+	}
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- session.Wait()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	result := &ExecResult{}
+	select {
+	case err = <-doneCh:
+	case <-timer.C:
+		result.TimedOut = true
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		err = <-doneCh
+	}
+	result.Duration = time.Since(start).Seconds()
+	result.Stdout, result.StdoutTruncated = decodeOutput(enc, stdout.data), stdout.truncated
+	result.Stderr, result.StderrTruncated = decodeOutput(enc, stderr.data), stderr.truncated
+	if !result.TimedOut {
+		var exitErr *ssh.ExitError
+		var missingErr *ssh.ExitMissingError
+		if err != nil && !errors.As(err, &exitErr) && !errors.As(err, &missingErr) {
+			return nil, err
+		}
+		result.ExitStatus, result.ExitSignal = exitStatus(err)
+	}
+	return result, nil
+}
+
+// 把远程输出转换为UTF-8，enc为空时不转换
+func decodeOutput(enc encoding.Encoding, data []byte) string {
+	if enc == nil {
+		return string(data)
+	}
+	if local, err := enc.NewDecoder().Bytes(data); err == nil {
+		return string(local)
+	}
+	return string(data)
+}
+
+// 保留前limit个字节的缓冲区，超出部分丢弃并标记截断
+type limitedBuffer struct {
+	data      []byte //已保留的数据
+	limit     int64  //最大字节数，为0时不限制
+	truncated bool   //是否丢弃过数据
+}
+
+// Write 写入数据，始终返回完整长度，远程进程不会因为截断而收到错误
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 {
+		if room := b.limit - int64(len(b.data)); int64(len(p)) > room {
+			p, b.truncated = p[:room], true
+		}
+	}
+	b.data = append(b.data, p...)
+	return n, nil
+}
@@ -0,0 +1,63 @@
+// Package core : 核心包
+package core
+
+import (
+	"golang.org/x/crypto/ssh" //ssh库
+	"time"                    //时间日期库
+)
+
+// CloseConnectionLost 与SSH服务器的连接中断时websocket关闭帧使用的状态码
+// 4000-4999为应用自定义状态码，浏览器据此与超时、正常退出区分
+const CloseConnectionLost = 4001
+
+// KeepaliveInterval 发送keepalive@openssh.com请求的间隔，为0时不发送
+var KeepaliveInterval = 30 * time.Second
+
+// KeepaliveMaxMissed 连续多少次没有收到回复时认为连接已经中断
+var KeepaliveMaxMissed = 3
+
+// keepalive 定时向服务端发送keepalive请求，连接中断时关闭返回的信道
+// client : SSH客户端
+// stopCh : 会话结束时关闭，停止发送
+func keepalive(client *ssh.Client, stopCh <-chan struct{}) <-chan struct{} {
+	lostCh := make(chan struct{})
+	if KeepaliveInterval <= 0 {
+		return lostCh //未开启时永远不会关闭
+	}
+	go func() {
+		ticker := time.NewTicker(KeepaliveInterval)
+		defer ticker.Stop()
+		replyCh := make(chan error, 1) //请求结果，带缓冲避免发送协程阻塞
+		pending := false               //是否有等待回复的请求
+		missed := 0                    //连续未回复次数
+		for {
+			select {
+			case <-stopCh:
+				return
+			case err := <-replyCh:
+				pending = false
+				//服务端不认识该请求时也会回复失败，只有连接关闭才返回错误
+				if err != nil {
+					close(lostCh)
+					return
+				}
+				missed = 0
+			case <-ticker.C:
+				//上一次请求还没有回复，连接已经中断时SendRequest会一直阻塞
+				if pending {
+					if missed++; missed >= KeepaliveMaxMissed {
+						close(lostCh)
+						return
+					}
+					continue
+				}
+				pending = true
+				go func() {
+					_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+					replyCh <- err
+				}()
+			}
+		}
+	}()
+	return lostCh
+}
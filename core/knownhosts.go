@@ -0,0 +1,299 @@
+// Package core : 核心包
+package core
+
+import (
+	"bufio"                              //按行读取
+	"crypto/hmac"                        //HMAC校验哈希主机名
+	"crypto/sha1"                        //SHA1哈希
+	"encoding/base64"                    //base64解码
+	"errors"                             //错误处理
+	"fmt"                                //格式化
+	"golang.org/x/crypto/ssh"            //ssh库
+	"golang.org/x/crypto/ssh/knownhosts" //known_hosts解析
+	"net"                                //网络库
+	"os"                                 //文件操作
+	"path/filepath"                      //路径处理
+	"strings"                            //字符串库
+	"sync"                               //同步锁
+)
+
+// 主机密钥校验策略
+const (
+	HostKeyStrict    = "strict"     //严格模式：只接受known_hosts中已记录的主机密钥
+	HostKeyTOFU      = "tofu"       //首次信任：首次连接自动记录，之后严格校验
+	HostKeyAcceptAll = "accept-all" //全部接受：不做任何校验(不安全，仅用于兼容旧行为)
+)
+
+// HostKeyResult 一次连接中主机密钥的校验结果
+type HostKeyResult struct {
+	Host        string `json:"host"`        //主机(known_hosts格式)
+	Type        string `json:"type"`        //密钥类型
+	Fingerprint string `json:"fingerprint"` //SHA256指纹
+	Added       bool   `json:"added"`       //是否为首次连接并已记录
+}
+
+// HostKeyEntry known_hosts中的一条记录
+type HostKeyEntry struct {
+	Line        int    `json:"line"`        //行号
+	Marker      string `json:"marker"`      //标记(@cert-authority/@revoked)
+	Hosts       string `json:"hosts"`       //主机列表
+	Type        string `json:"type"`        //密钥类型
+	Fingerprint string `json:"fingerprint"` //SHA256指纹
+	Comment     string `json:"comment"`     //注释
+}
+
+// KnownHosts OpenSSH格式的known_hosts存储
+type KnownHosts struct {
+	path   string     //文件路径
+	policy string     //校验策略
+	admins []string   //可以删除记录的web用户，*为全部用户
+	mu     sync.Mutex //读写文件锁
+}
+
+// HostKeys 全局known_hosts存储，启动时由InitKnownHosts替换
+var HostKeys = &KnownHosts{path: "known_hosts", policy: HostKeyTOFU}
+
+// InitKnownHosts 初始化全局known_hosts存储
+// path : known_hosts文件路径，不存在时自动创建
+// policy : 校验策略 strict/tofu/accept-all
+// admins : 可以删除记录的web用户，逗号分隔，*为全部用户，为空时不能通过接口删除
+func InitKnownHosts(path, policy, admins string) error {
+	switch policy {
+	case HostKeyStrict, HostKeyTOFU, HostKeyAcceptAll:
+	default:
+		return fmt.Errorf("unknown host key policy: %s", policy)
+	}
+	//创建文件所在目录
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	//确保文件存在
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	HostKeys = &KnownHosts{path: path, policy: policy}
+	for _, item := range strings.Split(admins, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			HostKeys.admins = append(HostKeys.admins, item)
+		}
+	}
+	return nil
+}
+
+// Policy 返回当前校验策略
+func (kh *KnownHosts) Policy() string {
+	return kh.policy
+}
+
+// CanRemove web用户是否可以删除记录，删除后下次连接会重新信任该主机提供的密钥
+func (kh *KnownHosts) CanRemove(webUser string) bool {
+	for _, user := range kh.admins {
+		if user == "*" || user == webUser {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches 记录是否属于查询的主机，明文主机按子串匹配，哈希主机名只能按完整的主机或主机:端口匹配
+func (entry HostKeyEntry) Matches(query string) bool {
+	return strings.Contains(entry.Hosts, query) || matchHosts(entry.Hosts, knownhosts.Normalize(query))
+}
+
+// HostKeyCallback 生成ssh.ClientConfig使用的主机密钥回调
+// result : 保存本次校验结果，用于把指纹返回给浏览器
+func (kh *KnownHosts) HostKeyCallback(result *HostKeyResult) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		result.Host = knownhosts.Normalize(hostname)
+		result.Type = key.Type()
+		result.Fingerprint = ssh.FingerprintSHA256(key)
+		if kh.policy == HostKeyAcceptAll {
+			return nil
+		}
+		kh.mu.Lock()
+		defer kh.mu.Unlock()
+		callback, err := knownhosts.New(kh.path)
+		if err != nil {
+			return err
+		}
+		err = callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err //校验通过或密钥已被吊销
+		}
+		//已有记录但不一致，可能遭受中间人攻击
+		if len(keyErr.Want) > 0 {
+			want := keyErr.Want[0]
+			return &CodeError{Code: CodeHostKeyMismatch, Err: fmt.Errorf(
+				"host key mismatch for %s: got %s %s, but %s:%d has %s %s",
+				result.Host, result.Type, result.Fingerprint,
+				want.Filename, want.Line, want.Key.Type(), ssh.FingerprintSHA256(want.Key))}
+		}
+		//严格模式下拒绝未知主机
+		if kh.policy == HostKeyStrict {
+			return &CodeError{Code: CodeHostKeyUnknown, Err: fmt.Errorf(
+				"host %s is not in known_hosts (%s %s)", result.Host, result.Type, result.Fingerprint)}
+		}
+		//首次信任，记录主机密钥
+		if err := kh.appendLine(knownhosts.Line([]string{hostname}, key)); err != nil {
+			return err
+		}
+		result.Added = true
+		return nil
+	}
+}
+
+// 仅用于查询已记录密钥类型的占位公钥
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "webssh-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("webssh-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
+
+// Algorithms 返回已记录的主机密钥算法，避免服务端优先提供其他类型的密钥而被误判为不一致
+// hostname : 主机:端口
+func (kh *KnownHosts) Algorithms(hostname string) []string {
+	if kh.policy == HostKeyAcceptAll {
+		return nil
+	}
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+	callback, err := knownhosts.New(kh.path)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if err := callback(hostname, &net.TCPAddr{}, probeKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algos []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			//RSA密钥可以使用SHA2签名算法
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, known.Key.Type())
+		}
+	}
+	return algos
+}
+
+// List 列出known_hosts中的全部记录
+func (kh *KnownHosts) List() ([]HostKeyEntry, error) {
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+	entries := make([]HostKeyEntry, 0)
+	err := kh.eachLine(func(line string, entry *HostKeyEntry) {
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	})
+	return entries, err
+}
+
+// Remove 删除指定主机的记录
+// host : 主机或主机:端口
+// fingerprint : 只删除该指纹的密钥，为空时删除该主机的全部密钥
+// 返回删除的记录数
+func (kh *KnownHosts) Remove(host, fingerprint string) (int, error) {
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+	target := knownhosts.Normalize(host)
+	var (
+		kept    []string //保留的行
+		removed int      //删除的记录数
+	)
+	err := kh.eachLine(func(line string, entry *HostKeyEntry) {
+		if entry != nil && matchHosts(entry.Hosts, target) &&
+			(fingerprint == "" || fingerprint == entry.Fingerprint) {
+			removed++
+			return
+		}
+		kept = append(kept, line)
+	})
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	//先写临时文件再替换，避免写一半时中断损坏文件
+	tmpPath := kh.path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(content), 0600); err != nil {
+		return 0, err
+	}
+	return removed, os.Rename(tmpPath, kh.path)
+}
+
+// 遍历known_hosts的每一行，注释、空行及无法解析的行entry为nil
+func (kh *KnownHosts) eachLine(fn func(line string, entry *HostKeyEntry)) error {
+	file, err := os.Open(kh.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		var entry *HostKeyEntry
+		if trimmed != "" && trimmed[0] != '#' {
+			marker, hosts, key, comment, _, err := ssh.ParseKnownHosts([]byte(trimmed))
+			if err == nil {
+				entry = &HostKeyEntry{
+					Line:        lineNum,
+					Marker:      marker,
+					Hosts:       strings.Join(hosts, ","),
+					Type:        key.Type(),
+					Fingerprint: ssh.FingerprintSHA256(key),
+					Comment:     comment,
+				}
+			}
+		}
+		fn(line, entry)
+	}
+	return scanner.Err()
+}
+
+// 追加一行到known_hosts
+func (kh *KnownHosts) appendLine(line string) error {
+	file, err := os.OpenFile(kh.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(line + "\n")
+	return err
+}
+
+// 判断known_hosts主机字段是否包含指定主机，支持哈希主机名
+func matchHosts(hosts, target string) bool {
+	for _, host := range strings.Split(hosts, ",") {
+		if host == target {
+			return true
+		}
+		//哈希格式 |1|salt|hash
+		if parts := strings.Split(host, "|"); len(parts) == 4 && parts[1] == "1" {
+			salt, err1 := base64.StdEncoding.DecodeString(parts[2])
+			hash, err2 := base64.StdEncoding.DecodeString(parts[3])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			mac := hmac.New(sha1.New, salt)
+			mac.Write([]byte(target))
+			if hmac.Equal(mac.Sum(nil), hash) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/ed25519"                     //测试密钥
+	"crypto/rand"                        //随机数
+	"golang.org/x/crypto/ssh"            //ssh库
+	"golang.org/x/crypto/ssh/knownhosts" //known_hosts格式
+	"os"                                 //文件操作
+	"path/filepath"                      //路径处理
+	"testing"                            //测试
+)
+
+func TestHostKeyEntryMatches(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ssh.NewPublicKey(pub)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	lines := knownhosts.Line([]string{"10.0.0.1"}, key) + "\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname("10.0.0.2")}, key) + "\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname("[10.0.0.3]:2222")}, key) + "\n"
+	if err := os.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitKnownHosts(path, HostKeyTOFU, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { HostKeys = &KnownHosts{path: "known_hosts", policy: HostKeyTOFU} })
+	entries, err := HostKeys.List()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("List = %d entries, %v", len(entries), err)
+	}
+	tests := []struct {
+		query string
+		want  []bool //每条记录是否匹配
+	}{
+		{"10.0.0.1", []bool{true, false, false}},
+		{"10.0.0", []bool{true, false, false}}, //哈希记录不能按子串匹配
+		{"10.0.0.2", []bool{false, true, false}},
+		{"10.0.0.2:22", []bool{false, true, false}},
+		{"10.0.0.3:2222", []bool{false, false, true}},
+		{"[10.0.0.3]:2222", []bool{false, false, true}},
+		{"10.0.0.3", []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			for i, entry := range entries {
+				if got := entry.Matches(tt.query); got != tt.want[i] {
+					t.Fatalf("entry %d (%s) Matches(%q) = %v, want %v", i, entry.Hosts, tt.query, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHostKeyCanRemove(t *testing.T) {
+	t.Cleanup(func() { HostKeys = &KnownHosts{path: "known_hosts", policy: HostKeyTOFU} })
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	tests := []struct {
+		admins  string
+		webUser string
+		want    bool
+	}{
+		{"", "", false},
+		{"", "admin", false},
+		{"admin", "admin", true},
+		{"admin", "guest", false},
+		{"admin", "", false},
+		{"ops, admin", "admin", true},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if err := InitKnownHosts(path, HostKeyTOFU, tt.admins); err != nil {
+			t.Fatal(err)
+		}
+		if got := HostKeys.CanRemove(tt.webUser); got != tt.want {
+			t.Errorf("admins %q: CanRemove(%q) = %v, want %v", tt.admins, tt.webUser, got, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"golang.org/x/crypto/ssh"      //ssh包
 	"io"                           //io操作
 	"log"                          //日志记录
+	"strings"                      //无效字符替换
 	"unicode/utf8"                 //utf8字符编码解码
 )
 
@@ -27,47 +28,69 @@ func (wc *WriteCounter) Write(p []byte) (int, error) {
 	return n, nil //返回成功写入的字节数与错误码
 }
 
-// 输出Websocket连接对象
+// 输出Websocket连接对象，旧协议以文本消息发送输出，文本消息必须是有效的UTF-8
+// 跨两次写入的多字节字符合并后再发送，无效的字节替换为U+FFFD
 type wsOutput struct {
-	ws *websocket.Conn
+	ws      *websocket.Conn
+	partial []byte //上一次写入末尾不完整的字符
 }
 
 // Write: 为wsOutput实现Write方法
 // p:要写入的字节数组
 func (w *wsOutput) Write(p []byte) (int, error) {
-	// 处理非utf8字符
-	if !utf8.Valid(p) {
-		bufStr := string(p) //转换为字符串
-		//rune表示int32,[]rune相当于[]int32
-		buf := make([]rune, 0, len(bufStr)) //分配内存用于存储上面bufStr字符串
-		//遍历字符串
-		for _, r := range bufStr {
-			//如果是非法unicode字符
-			if r == utf8.RuneError {
-				buf = append(buf, []rune("@")...) //替换为@
-			} else {
-				buf = append(buf, r) //拼接字符
-			}
-		}
-		p = []byte(string(buf)) //字符串强制转换为字节数组
+	data := append(w.partial, p...) //拼接上一次剩余的字节
+	cut := incompleteRune(data)     //末尾不完整字符的位置
+	w.partial = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return len(p), nil //只有不完整的字符，等待下一次写入
 	}
+	text := strings.ToValidUTF8(string(data[:cut]), "\uFFFD")
 	//向websocket发送文本消息
-	err := w.ws.WriteMessage(websocket.TextMessage, p)
-	//返回已发送的字节长度与错误码
+	err := w.ws.WriteMessage(websocket.TextMessage, []byte(text))
+	//返回已写入的字节长度与错误码
 	return len(p), err
 }
 
+// 返回data末尾不完整的UTF-8字符的起始位置，末尾完整时返回len(data)
+// 最多检查3个字节，更早的无效字节不需要等待后续输出
+func incompleteRune(data []byte) int {
+	for i := 1; i <= 3 && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return len(data) - i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
 // SSHClient 结构体
 type SSHClient struct {
-	Username  string         `json:"username"`  //用户名
-	Password  string         `json:"password"`  //密码
-	IPAddress string         `json:"ipaddress"` //IP地址
-	Port      int            `json:"port"`      //端口
-	LoginType int            `json:"logintype"` //登陆类型
-	Client    *ssh.Client    //SSH客户端
-	Sftp      *sftp.Client   //SFTP客户端
-	StdinPipe io.WriteCloser //写IO接口，这里表示标准输入管道
-	Session   *ssh.Session   //SSH会话
+	Username    string                `json:"username"`    //用户名
+	Password    string                `json:"password"`    //密码
+	IPAddress   string                `json:"ipaddress"`   //IP地址
+	Port        int                   `json:"port"`        //端口
+	LoginType   int                   `json:"logintype"`   //登陆类型
+	Passphrase  string                `json:"passphrase"`  //私钥密码短语
+	JumpHosts   []SSHClient           `json:"jumphosts"`   //跳板机，按连接顺序排列，每一跳有独立的认证信息
+	Proxy       string                `json:"proxy"`       //连接使用的代理，覆盖服务端默认代理，direct表示不使用代理
+	Certificate string                `json:"certificate"` //OpenSSH用户证书，与私钥一起使用
+	Algorithms  string                `json:"algorithms"`  //算法配置: modern, compatible, legacy，为空时使用服务端配置
+	Encoding    string                `json:"encoding"`    //远程主机的字符编码: utf-8(默认), gbk, gb18030, big5
+	Client      *ssh.Client           //SSH客户端
+	Sftp        *sftp.Client          //SFTP客户端
+	StdinPipe   io.WriteCloser        //写IO接口，这里表示标准输入管道
+	Session     *ssh.Session          //SSH会话
+	HostKey     HostKeyResult         `json:"-"` //主机密钥校验结果
+	WebUser     string                `json:"-"` //登录webssh的用户，内置CA签发证书时使用
+	ClientIP    string                `json:"-"` //浏览器IP，写入审计日志
+	Negotiated  *NegotiatedAlgorithms `json:"-"` //与服务端协商出的算法
+	//键盘交互认证问题转发，为空时只能自动回答密码问题
+	Challenge   ssh.KeyboardInteractiveChallenge `json:"-"`
+	jumpClients []*ssh.Client                    //跳板机SSH客户端
+	shared      bool                             //是否与连接池共享SSH连接，共享时Close不关闭连接及sftp客户端
+	pool        *pooledConn                      //共享的连接池连接
 }
 
 // NewSSHClient 创建新的SSH客户端实例并使用默认用户名root及默认端口22
@@ -78,8 +101,8 @@ func NewSSHClient() SSHClient {
 	return client            //返回SSH客户端实例
 }
 
-// Close 关闭SSHClient结构中StdinPipe, Session, Sftp, Client
-// 这4个字符中所有打开的连接
+// Close 关闭SSHClient结构中StdinPipe, Session, Sftp, Client及跳板机
+// 这些字段中所有打开的连接
 func (sclient *SSHClient) Close() {
 	//Close方法所有语句执行完成后，执行这个函数
 	defer func() {
@@ -97,6 +120,13 @@ func (sclient *SSHClient) Close() {
 		sclient.Session.Close()
 		sclient.Session = nil
 	}
+	//共享的SSH连接及sftp客户端由连接池关闭
+	if sclient.shared {
+		sclient.Sftp = nil
+		sclient.Client = nil
+		sclient.jumpClients = nil
+		return
+	}
 	//关闭SFTP文件传入连接
 	if sclient.Sftp != nil {
 		sclient.Sftp.Close()
@@ -107,4 +137,9 @@ func (sclient *SSHClient) Close() {
 		sclient.Client.Close()
 		sclient.Client = nil
 	}
+	//从最后一跳开始关闭跳板机连接
+	for i := len(sclient.jumpClients) - 1; i >= 0; i-- {
+		sclient.jumpClients[i].Close()
+	}
+	sclient.jumpClients = nil
 }
@@ -0,0 +1,128 @@
+// Package core : 核心包
+package core
+
+import (
+	"fmt"                          //格式化
+	"github.com/gorilla/websocket" //websocket库
+	"net/http"                     //http服务
+	"net/http/httptest"            //测试服务器
+	"strings"                      //字符串拼接
+	"testing"                      //测试
+	"time"                         //关闭超时
+	"unicode/utf8"                 //utf8校验
+)
+
+// 建立一对websocket连接，返回服务端及浏览器端
+func websocketPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- ws
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+// 读取服务端关闭前收到的全部文本消息
+func readTextMessages(t *testing.T, client *websocket.Conn) []string {
+	t.Helper()
+	var messages []string
+	for {
+		messageType, data, err := client.ReadMessage()
+		if err != nil {
+			return messages
+		}
+		if messageType != websocket.TextMessage {
+			t.Fatalf("message type %d, want text", messageType)
+		}
+		messages = append(messages, string(data))
+	}
+}
+
+func TestIncompleteRune(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "abc", 3},
+		{"complete 2 bytes", "aé", 3},
+		{"complete 3 bytes", "a中", 4},
+		{"complete 4 bytes", "a😀", 5},
+		{"1 of 2 bytes", "a\xc3", 1},
+		{"1 of 3 bytes", "a\xe4", 1},
+		{"2 of 3 bytes", "a\xe4\xb8", 1},
+		{"3 of 4 bytes", "a\xf0\x9f\x98", 1},
+		{"only partial", "\xe4\xb8", 0},
+		{"stray continuation", "a\x80", 2},
+		{"invalid lead byte", "a\xff", 2},
+		{"continuations beyond 3 bytes", "a\x80\x80\x80\x80", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := incompleteRune([]byte(tt.data)); got != tt.want {
+				t.Fatalf("incompleteRune(%q) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWsOutputSplit(t *testing.T) {
+	text := "a中文é😀z"
+	type testCase struct {
+		name   string
+		writes []string
+		want   string
+	}
+	tests := []testCase{
+		{"single write", []string{text}, text},
+		{"invalid byte replaced", []string{"a\xffb"}, "a\uFFFDb"},
+		{"partial completed by next write", []string{"ab\xe4", "\xb8\xad"}, "ab中"},
+	}
+	var bytewise []string
+	for i := 0; i < len(text); i++ {
+		bytewise = append(bytewise, text[i:i+1])
+	}
+	tests = append(tests, testCase{"byte by byte", bytewise, text})
+	//在每个字节位置拆分为两次写入
+	for i := 1; i < len(text); i++ {
+		tests = append(tests, testCase{fmt.Sprintf("split at %d", i), []string{text[:i], text[i:]}, text})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := websocketPair(t)
+			output := &wsOutput{ws: server}
+			for _, w := range tt.writes {
+				if n, err := output.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			server.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			messages := readTextMessages(t, client)
+			for _, message := range messages {
+				if !utf8.ValidString(message) {
+					t.Fatalf("invalid UTF-8 message %q", message)
+				}
+			}
+			if got := strings.Join(messages, ""); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
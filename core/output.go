@@ -0,0 +1,246 @@
+// Package core : 核心包
+package core
+
+import (
+	"errors"                       //错误处理
+	"github.com/gorilla/websocket" //websocket库
+	"sync/atomic"                  //统计计数
+	"time"                         //时间日期库
+)
+
+// 终端输出合并及流控配置
+var (
+	OutputBatchWindow = 5 * time.Millisecond //两次发送之间的最小间隔，期间的输出合并为一条消息，为0时不合并
+	OutputMaxInflight = 256 * 1024           //每个websocket排队未发送的最大输出字节数，超过时暂停读取SSH输出
+)
+
+// 单条websocket消息合并的最大输出字节数
+const outputFrameSize = 64 * 1024
+
+// websocket关闭后写入的错误
+var errConnClosed = errors.New("websocket closed")
+
+// 输出统计
+var outputMetrics struct {
+	chunks       atomic.Int64 //SSH输出次数
+	coalesced    atomic.Int64 //合并到已排队消息中的输出次数
+	frames       atomic.Int64 //发送的输出消息数
+	bytes        atomic.Int64 //发送的输出字节数
+	delayed      atomic.Int64 //等待合并间隔后发送的次数
+	stalls       atomic.Int64 //排队超过上限而暂停读取SSH输出的次数
+	stallNanos   atomic.Int64 //暂停读取的总时间
+	dropped      atomic.Int64 //长时间不读取被断开的连接数
+	droppedBytes atomic.Int64 //连接断开时丢弃的排队输出字节数
+}
+
+// OutputMetrics 终端输出统计
+type OutputMetrics struct {
+	Chunks       int64   `json:"chunks"`       //SSH输出次数
+	Coalesced    int64   `json:"coalesced"`    //合并到已排队消息中的输出次数
+	Frames       int64   `json:"frames"`       //发送的输出消息数
+	Bytes        int64   `json:"bytes"`        //发送的输出字节数
+	Delayed      int64   `json:"delayed"`      //等待合并间隔后发送的次数
+	Stalls       int64   `json:"stalls"`       //排队超过上限而暂停读取SSH输出的次数
+	StallSeconds float64 `json:"stallSeconds"` //暂停读取的总时间(s)
+	Dropped      int64   `json:"dropped"`      //长时间不读取被断开的连接数
+	DroppedBytes int64   `json:"droppedBytes"` //连接断开时丢弃的排队输出字节数
+	Terminals    int     `json:"terminals"`    //当前终端数
+	Connections  int     `json:"connections"`  //当前websocket连接数
+	Queued       int64   `json:"queued"`       //当前排队未发送的输出字节数
+	BatchWindow  float64 `json:"batchWindow"`  //合并间隔(s)
+	MaxInflight  int     `json:"maxInflight"`  //每个连接排队的最大字节数
+}
+
+// GetOutputMetrics 返回终端输出统计及当前排队情况
+func GetOutputMetrics() OutputMetrics {
+	metrics := OutputMetrics{
+		Chunks:       outputMetrics.chunks.Load(),
+		Coalesced:    outputMetrics.coalesced.Load(),
+		Frames:       outputMetrics.frames.Load(),
+		Bytes:        outputMetrics.bytes.Load(),
+		Delayed:      outputMetrics.delayed.Load(),
+		Stalls:       outputMetrics.stalls.Load(),
+		StallSeconds: time.Duration(outputMetrics.stallNanos.Load()).Seconds(),
+		Dropped:      outputMetrics.dropped.Load(),
+		DroppedBytes: outputMetrics.droppedBytes.Load(),
+		BatchWindow:  OutputBatchWindow.Seconds(),
+		MaxInflight:  OutputMaxInflight,
+	}
+	Terminals.mu.Lock()
+	terms := make([]*Terminal, 0, len(Terminals.terms))
+	for _, term := range Terminals.terms {
+		terms = append(terms, term)
+	}
+	Terminals.mu.Unlock()
+	metrics.Terminals = len(terms)
+	for _, term := range terms {
+		term.mu.Lock()
+		for _, sub := range term.subs {
+			metrics.Connections++
+			metrics.Queued += int64(sub.ws.Queued())
+		}
+		term.mu.Unlock()
+	}
+	return metrics
+}
+
+// 排队等待发送的消息
+type outFrame struct {
+	messageType int    //websocket消息类型，输出为0
+	data        []byte //远程输出或已编码的消息
+}
+
+// 加入发送队列，相邻的远程输出合并为一条消息
+func (conn *TermConn) enqueue(messageType int, data []byte) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return conn.err
+	}
+	if messageType == 0 {
+		outputMetrics.chunks.Add(1)
+		conn.queued += len(data)
+		if last := len(conn.frames) - 1; last >= 0 && conn.frames[last].messageType == 0 &&
+			len(conn.frames[last].data)+len(data) <= outputFrameSize {
+			conn.frames[last].data = append(conn.frames[last].data, data...)
+			outputMetrics.coalesced.Add(1)
+			conn.cond.Broadcast()
+			return nil
+		}
+	}
+	conn.frames = append(conn.frames, outFrame{messageType: messageType, data: append([]byte(nil), data...)})
+	conn.cond.Broadcast()
+	return nil
+}
+
+// Queued 返回排队未发送的输出字节数
+func (conn *TermConn) Queued() int {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.queued
+}
+
+// Wait 排队的输出超过OutputMaxInflight时等待浏览器读取，超时返回false
+// 终端在转发SSH输出后调用，浏览器读取慢时暂停读取SSH输出
+func (conn *TermConn) Wait(timeout time.Duration) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if OutputMaxInflight <= 0 || conn.queued < OutputMaxInflight || conn.err != nil {
+		return true
+	}
+	outputMetrics.stalls.Add(1)
+	start := time.Now()
+	defer func() { outputMetrics.stallNanos.Add(int64(time.Since(start))) }()
+	timer := time.AfterFunc(timeout, func() {
+		conn.mu.Lock()
+		conn.cond.Broadcast()
+		conn.mu.Unlock()
+	})
+	defer timer.Stop()
+	for conn.queued >= OutputMaxInflight && conn.err == nil {
+		if time.Since(start) >= timeout {
+			outputMetrics.dropped.Add(1)
+			return false
+		}
+		conn.cond.Wait()
+	}
+	return true
+}
+
+// CloseWith 发送完排队的消息后发送关闭帧并关闭连接
+// code : websocket关闭状态码
+// reason : 关闭原因
+func (conn *TermConn) CloseWith(code int, reason string) {
+	conn.enqueue(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	conn.Close()
+}
+
+// Close 不再接受新的消息，排队的消息在1秒内发送完后关闭连接
+func (conn *TermConn) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return nil
+	}
+	conn.err = errConnClosed
+	conn.cond.Broadcast()
+	time.AfterFunc(time.Second, func() { conn.Conn.Close() }) //浏览器不读取时强制关闭
+	return nil
+}
+
+// 发送协程，按OutputBatchWindow的间隔合并输出后写入websocket
+func (conn *TermConn) send() {
+	defer close(conn.done)
+	defer conn.Conn.Close()
+	var last time.Time //上一次发送的时间
+	for {
+		conn.mu.Lock()
+		for len(conn.frames) == 0 && conn.err == nil {
+			conn.cond.Wait()
+		}
+		if len(conn.frames) == 0 {
+			conn.mu.Unlock()
+			return
+		}
+		//距上一次发送不足合并间隔时等待，期间的输出合并到同一条消息
+		if wait := OutputBatchWindow - time.Since(last); wait > 0 && conn.err == nil {
+			outputMetrics.delayed.Add(1)
+			conn.mu.Unlock()
+			time.Sleep(wait)
+			conn.mu.Lock()
+		}
+		frames := conn.frames
+		conn.frames = nil
+		conn.mu.Unlock()
+		last = time.Now()
+		for i, frame := range frames {
+			if err := conn.write(frame); err != nil {
+				conn.mu.Lock()
+				for _, rest := range frames[i:] {
+					if rest.messageType == 0 {
+						outputMetrics.droppedBytes.Add(int64(len(rest.data)))
+					}
+				}
+				for _, rest := range conn.frames {
+					if rest.messageType == 0 {
+						outputMetrics.droppedBytes.Add(int64(len(rest.data)))
+					}
+				}
+				conn.frames, conn.queued = nil, 0
+				if conn.err == nil {
+					conn.err = err
+				}
+				conn.cond.Broadcast()
+				conn.mu.Unlock()
+				return
+			}
+			if frame.messageType == 0 {
+				conn.mu.Lock()
+				conn.queued -= len(frame.data)
+				conn.cond.Broadcast()
+				conn.mu.Unlock()
+			}
+		}
+	}
+}
+
+// 按协议版本写入一条消息
+func (conn *TermConn) write(frame outFrame) error {
+	deadline := time.Now().Add(wsWriteTimeout)
+	switch frame.messageType {
+	case 0: //远程输出
+		outputMetrics.frames.Add(1)
+		outputMetrics.bytes.Add(int64(len(frame.data)))
+		conn.SetWriteDeadline(deadline)
+		if conn.Protocol == ProtocolV2 {
+			return conn.WriteMessage(websocket.BinaryMessage, frame.data)
+		}
+		_, err := conn.output.Write(frame.data)
+		return err
+	case websocket.CloseMessage:
+		return conn.WriteControl(websocket.CloseMessage, frame.data, time.Now().Add(time.Second))
+	default:
+		conn.SetWriteDeadline(deadline)
+		return conn.WriteMessage(frame.messageType, frame.data)
+	}
+}
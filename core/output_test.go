@@ -0,0 +1,173 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"                        //字节比较
+	"github.com/gorilla/websocket" //websocket库
+	"sync"                         //条件变量
+	"testing"                      //测试
+	"time"                         //超时
+)
+
+// 未启动发送协程的连接，排队的消息保留在队列中
+func queuedTermConn() *TermConn {
+	conn := &TermConn{Protocol: ProtocolV2, done: make(chan struct{})}
+	conn.cond = sync.NewCond(&conn.mu)
+	return conn
+}
+
+// 修改OutputMaxInflight，测试结束后恢复
+func setMaxInflight(t *testing.T, limit int) {
+	old := OutputMaxInflight
+	OutputMaxInflight = limit
+	t.Cleanup(func() { OutputMaxInflight = old })
+}
+
+func TestEnqueueCoalesce(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), outputFrameSize-2)
+	type message struct {
+		messageType int
+		data        string
+	}
+	tests := []struct {
+		name     string
+		messages []message
+		frames   []int //每条排队消息的长度
+		queued   int
+	}{
+		{"adjacent output merged", []message{{0, "ab"}, {0, "cd"}, {0, "e"}}, []int{5}, 5},
+		{"text message not merged", []message{{0, "ab"}, {websocket.TextMessage, "{}"}, {0, "cd"}}, []int{2, 2, 2}, 4},
+		{"frame size limit", []message{{0, string(big)}, {0, "abc"}, {0, "de"}}, []int{outputFrameSize - 2, 5}, outputFrameSize + 3},
+		{"fills frame exactly", []message{{0, string(big)}, {0, "ab"}, {0, "c"}}, []int{outputFrameSize, 1}, outputFrameSize + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := queuedTermConn()
+			for _, m := range tt.messages {
+				if err := conn.enqueue(m.messageType, []byte(m.data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var frames []int
+			for _, frame := range conn.frames {
+				frames = append(frames, len(frame.data))
+			}
+			if len(frames) != len(tt.frames) {
+				t.Fatalf("frames %v, want %v", frames, tt.frames)
+			}
+			for i := range frames {
+				if frames[i] != tt.frames[i] {
+					t.Fatalf("frames %v, want %v", frames, tt.frames)
+				}
+			}
+			if conn.Queued() != tt.queued {
+				t.Fatalf("queued %d, want %d", conn.Queued(), tt.queued)
+			}
+		})
+	}
+}
+
+func TestEnqueueCopies(t *testing.T) {
+	conn := queuedTermConn()
+	data := []byte("abc")
+	conn.enqueue(0, data)
+	data[0] = 'x'
+	if string(conn.frames[0].data) != "abc" {
+		t.Fatalf("queued data changed to %q", conn.frames[0].data)
+	}
+}
+
+func TestEnqueueAfterError(t *testing.T) {
+	conn := queuedTermConn()
+	conn.err = errConnClosed
+	if err := conn.Output([]byte("abc")); err != errConnClosed {
+		t.Fatalf("got %v, want %v", err, errConnClosed)
+	}
+	if conn.Queued() != 0 || len(conn.frames) != 0 {
+		t.Fatal("output queued after close")
+	}
+}
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		queued  int
+		closed  bool
+		drain   bool //等待期间浏览器读取了排队的输出
+		want    bool
+		minWait time.Duration
+	}{
+		{"below limit", 10, 9, false, false, true, 0},
+		{"no limit", 0, 1 << 20, false, false, true, 0},
+		{"closed", 10, 10, true, false, true, 0},
+		{"timeout", 10, 10, false, false, false, 50 * time.Millisecond},
+		{"drained", 10, 20, false, true, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setMaxInflight(t, tt.limit)
+			conn := queuedTermConn()
+			conn.queued = tt.queued
+			if tt.closed {
+				conn.err = errConnClosed
+			}
+			if tt.drain {
+				time.AfterFunc(10*time.Millisecond, func() {
+					conn.mu.Lock()
+					conn.queued = 0
+					conn.cond.Broadcast()
+					conn.mu.Unlock()
+				})
+			}
+			start := time.Now()
+			if got := conn.Wait(50 * time.Millisecond); got != tt.want {
+				t.Fatalf("Wait = %v, want %v", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minWait || elapsed > time.Second {
+				t.Fatalf("Wait took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestTermConnSend(t *testing.T) {
+	setMaxInflight(t, 1<<20)
+	server, client := websocketPair(t)
+	conn := NewTermConn(server, ProtocolV2)
+	var want []byte
+	for i := 0; i < 100; i++ {
+		chunk := bytes.Repeat([]byte{byte('a' + i%26)}, i+1)
+		want = append(want, chunk...)
+		if err := conn.Output(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.CloseWith(websocket.CloseNormalClosure, "")
+	var got []byte
+	frames := 0
+	for {
+		messageType, data, err := client.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("read: %v", err)
+			}
+			break
+		}
+		if messageType != websocket.BinaryMessage {
+			t.Fatalf("message type %d, want binary", messageType)
+		}
+		got = append(got, data...)
+		frames++
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("received %d bytes out of order or incomplete, want %d", len(got), len(want))
+	}
+	if frames >= 100 {
+		t.Fatalf("%d frames for 100 outputs, want coalesced", frames)
+	}
+	<-conn.done
+	if conn.Queued() != 0 {
+		t.Fatalf("queued %d after send", conn.Queued())
+	}
+}
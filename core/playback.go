@@ -0,0 +1,464 @@
+// Package core : 核心包
+package core
+
+import (
+	"bufio"                        //逐行读取录像
+	"encoding/json"                //asciicast解析
+	"errors"                       //错误处理
+	"fmt"                          //格式化
+	"github.com/gorilla/websocket" //websocket库
+	"io"                           //io操作
+	"os"                           //文件操作
+	"path"                         //通配符匹配
+	"path/filepath"                //录像文件路径
+	"regexp"                       //录像名称校验
+	"sort"                         //排序
+	"strings"                      //字符串库
+	"time"                         //时间日期库
+	"unicode/utf8"                 //字符处理
+)
+
+// CodeRecordingNotFound 录像不存在、未开启录像或不属于当前web用户的错误码
+const CodeRecordingNotFound = "RECORDING_NOT_FOUND"
+
+// 录像名称为开始时间及终端ID
+var recordingName = regexp.MustCompile(`^\d{8}-\d{6}-[A-Za-z0-9_-]+$`)
+
+// RecordingFilter 录像查询条件，字段为空时不过滤
+type RecordingFilter struct {
+	WebUser string    //webssh用户，只能查询自己的录像
+	User    string    //ssh登录用户通配符
+	Host    string    //目标主机通配符
+	From    time.Time //开始时间下限
+	To      time.Time //开始时间上限
+}
+
+// ListRecordings 按条件列出录像，按开始时间倒序排列
+func ListRecordings(filter RecordingFilter) ([]RecordingInfo, error) {
+	if RecordDir == "" {
+		return nil, &CodeError{Code: CodeRecordingNotFound, Err: errors.New("recording is not enabled")}
+	}
+	files, err := filepath.Glob(filepath.Join(RecordDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]RecordingInfo, 0)
+	for _, name := range files {
+		info, err := readRecordingInfo(filepath.Join(RecordDir, filepath.Base(name)))
+		if err != nil || info.WebUser != filter.WebUser {
+			continue
+		}
+		if filter.User != "" {
+			if matched, _ := path.Match(filter.User, info.User); !matched {
+				continue
+			}
+		}
+		if filter.Host != "" {
+			if matched, _ := path.Match(filter.Host, info.Host); !matched {
+				continue
+			}
+		}
+		if (!filter.From.IsZero() && info.Start.Before(filter.From)) || (!filter.To.IsZero() && info.Start.After(filter.To)) {
+			continue
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.After(list[j].Start) })
+	return list, nil
+}
+
+// 读取元数据文件，正在进行的录像时长按当前时间计算
+func readRecordingInfo(name string) (RecordingInfo, error) {
+	var info RecordingInfo
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, err
+	}
+	if info.Active {
+		info.Duration = time.Since(info.Start).Seconds()
+	}
+	return info, nil
+}
+
+// GetRecording 取录像元数据
+// name : 录像名称
+// webUser : 当前web用户
+func GetRecording(name, webUser string) (RecordingInfo, error) {
+	notFound := &CodeError{Code: CodeRecordingNotFound, Err: errors.New("recording not found")}
+	if RecordDir == "" || !recordingName.MatchString(name) {
+		return RecordingInfo{}, notFound
+	}
+	info, err := readRecordingInfo(filepath.Join(RecordDir, name+".json"))
+	if err != nil || info.WebUser != webUser {
+		return RecordingInfo{}, notFound
+	}
+	return info, nil
+}
+
+// asciicast事件
+type castEvent struct {
+	Time float64 //相对开始的秒数
+	Kind string  //事件类型: o输出, r调整大小, m标记
+	Data string  //事件数据
+}
+
+// 顺序读取录像文件
+type castReader struct {
+	file    *os.File       //录像文件
+	scanner *bufio.Scanner //按行读取
+	header  castHeader     //文件头
+	pending *castEvent     //跳转时预读的事件
+}
+
+// 打开录像文件并读取文件头
+func openCast(name string) (*castReader, error) {
+	file, err := os.Open(filepath.Join(RecordDir, name+".cast"))
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) //单个事件最长16MB
+	reader := &castReader{file: file, scanner: scanner}
+	if !scanner.Scan() {
+		file.Close()
+		return nil, fmt.Errorf("invalid recording %s", name)
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &reader.header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// 读取下一个事件，读完时返回io.EOF，跳过无法解析的行
+func (reader *castReader) next() (castEvent, error) {
+	if event := reader.pending; event != nil {
+		reader.pending = nil
+		return *event, nil
+	}
+	for reader.scanner.Scan() {
+		var raw []interface{}
+		if err := json.Unmarshal(reader.scanner.Bytes(), &raw); err != nil || len(raw) != 3 {
+			continue
+		}
+		t, ok1 := raw[0].(float64)
+		kind, ok2 := raw[1].(string)
+		data, ok3 := raw[2].(string)
+		if ok1 && ok2 && ok3 {
+			return castEvent{Time: t, Kind: kind, Data: data}, nil
+		}
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return castEvent{}, err
+	}
+	return castEvent{}, io.EOF
+}
+
+// 关闭录像文件
+func (reader *castReader) close() {
+	reader.file.Close()
+}
+
+// PlayMessage 回放websocket消息
+// 服务端发送header、o(输出)、r(调整大小)、reset(跳转后清空终端并写入data)、end(回放结束)
+// 浏览器发送seek、speed、pause、resume
+type PlayMessage struct {
+	Type     string         `json:"type"`               //消息类型
+	Time     float64        `json:"time,omitempty"`     //录像时间(s)
+	Data     string         `json:"data,omitempty"`     //输出内容
+	Cols     int            `json:"cols,omitempty"`     //终端列数
+	Rows     int            `json:"rows,omitempty"`     //终端行数
+	Speed    float64        `json:"speed,omitempty"`    //回放速度
+	Info     *RecordingInfo `json:"info,omitempty"`     //录像元数据，只在header中发送
+	Duration float64        `json:"duration,omitempty"` //录像时长，只在header中发送
+}
+
+// Playback 通过websocket回放录像，按录像中的时间间隔发送输出
+// ws : websocket连接
+// info : 录像元数据
+// speed : 初始回放速度
+// idle : 最长等待时间(s)，录像中更长的空闲被缩短，为0时不限制
+func Playback(ws *websocket.Conn, info RecordingInfo, speed, idle float64) error {
+	defer ws.Close()
+	if speed <= 0 {
+		speed = 1
+	}
+	reader, err := openCast(info.Name)
+	if err != nil {
+		return err
+	}
+	defer func() { reader.close() }()
+	if err := ws.WriteJSON(PlayMessage{Type: "header", Cols: reader.header.Width, Rows: reader.header.Height,
+		Info: &info, Duration: info.Duration, Speed: speed}); err != nil {
+		return nil
+	}
+	//读取浏览器的控制消息
+	ctrlCh := make(chan PlayMessage)
+	closedCh := make(chan struct{})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go func() {
+		defer close(closedCh)
+		for {
+			var msg PlayMessage
+			if err := ws.ReadJSON(&msg); err != nil {
+				if _, ok := err.(*json.SyntaxError); ok {
+					continue
+				}
+				return
+			}
+			select {
+			case ctrlCh <- msg:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	position := 0.0      //当前回放到的录像时间
+	paused := false      //是否暂停
+	ended := false       //是否已读完
+	var event *castEvent //下一个要发送的事件
+	for {
+		if event == nil && !ended {
+			next, err := reader.next()
+			if err == io.EOF {
+				ended = true
+				if err := ws.WriteJSON(PlayMessage{Type: "end", Time: position}); err != nil {
+					return nil
+				}
+			} else if err != nil {
+				return err
+			} else {
+				event = &next
+			}
+		}
+		//按回放速度等待到下一个事件的时间，暂停或结束时只等待控制消息
+		var timerCh <-chan time.Time
+		var timer *time.Timer
+		waitStart := time.Now()
+		if event != nil && !paused {
+			wait := event.Time - position
+			if idle > 0 && wait > idle {
+				wait = idle
+			}
+			timer = time.NewTimer(time.Duration(wait / speed * float64(time.Second)))
+			timerCh = timer.C
+		}
+		select {
+		case <-timerCh:
+			position = event.Time
+			if err := sendPlayEvent(ws, *event); err != nil {
+				return nil
+			}
+			event = nil
+		case msg := <-ctrlCh:
+			if timer != nil {
+				timer.Stop()
+				//记录已经回放到的位置
+				if position += time.Since(waitStart).Seconds() * speed; position > event.Time {
+					position = event.Time
+				}
+			}
+			switch msg.Type {
+			case "pause":
+				paused = true
+			case "resume":
+				paused = false
+				if ended && event == nil {
+					msg = PlayMessage{Type: "seek"} //结束后继续即从头回放
+				}
+			case "speed":
+				if msg.Speed > 0 {
+					speed = msg.Speed
+				}
+			}
+			if msg.Type == "seek" {
+				next, reset, err := seekCast(info.Name, msg.Time)
+				if err != nil {
+					return err
+				}
+				reader.close()
+				reader, event, ended, position = next, nil, false, msg.Time
+				if err := ws.WriteJSON(reset); err != nil {
+					return nil
+				}
+			}
+		case <-closedCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		}
+	}
+}
+
+// 发送一个录像事件，标记事件不发送
+func sendPlayEvent(ws *websocket.Conn, event castEvent) error {
+	switch event.Kind {
+	case "o":
+		return ws.WriteJSON(PlayMessage{Type: "o", Time: event.Time, Data: event.Data})
+	case "r":
+		var cols, rows int
+		if _, err := fmt.Sscanf(event.Data, "%dx%d", &cols, &rows); err == nil {
+			return ws.WriteJSON(PlayMessage{Type: "r", Time: event.Time, Cols: cols, Rows: rows})
+		}
+	}
+	return nil
+}
+
+// 从头读取录像到指定时间，返回停在该时间的读取器及合并了之前输出的reset消息
+// 与重新连接终端时的回放一样只保留最后TerminalBufferSize字节，大录像不会一次占用大量内存
+func seekCast(name string, t float64) (*castReader, PlayMessage, error) {
+	reader, err := openCast(name)
+	if err != nil {
+		return nil, PlayMessage{}, err
+	}
+	reset := PlayMessage{Type: "reset", Time: t, Cols: reader.header.Width, Rows: reader.header.Height}
+	output := newRingBuffer(TerminalBufferSize)
+	for {
+		event, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reader.close()
+			return nil, reset, err
+		}
+		if event.Time > t {
+			reader.pending = &event //目标时间之后的第一个事件留给回放
+			break
+		}
+		switch event.Kind {
+		case "o":
+			output.Write([]byte(event.Data))
+		case "r":
+			fmt.Sscanf(event.Data, "%dx%d", &reset.Cols, &reset.Rows)
+		}
+	}
+	data := output.Since(0)
+	//截断处可能在多字节字符中间
+	for len(data) > 0 && !utf8.RuneStart(data[0]) {
+		data = data[1:]
+	}
+	reset.Data = string(data)
+	return reader, reset, nil
+}
+
+// ExportRecording 把录像导出为纯文本，去掉控制序列并处理回车及退格
+func ExportRecording(w io.Writer, info RecordingInfo) error {
+	reader, err := openCast(info.Name)
+	if err != nil {
+		return err
+	}
+	defer reader.close()
+	text := &textWriter{w: bufio.NewWriter(w)}
+	for {
+		event, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if event.Kind == "o" {
+			text.write(event.Data)
+		}
+	}
+	return text.flush()
+}
+
+// 终端输出转纯文本，逐行维护光标位置
+type textWriter struct {
+	w      *bufio.Writer //输出
+	line   []rune        //当前行
+	col    int           //光标所在列
+	escape int           //转义序列状态: 0普通, 1收到ESC, 2 CSI, 3 OSC等字符串序列, 4字符串序列中收到ESC
+}
+
+// 处理一段输出
+func (t *textWriter) write(data string) {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRuneInString(data)
+		data = data[size:]
+		switch t.escape {
+		case 1:
+			switch r {
+			case '[':
+				t.escape = 2
+			case ']', 'P', '_', '^', 'X':
+				t.escape = 3
+			case '(', ')', '*', '+', '#', '%':
+				t.escape = 5 //后面还有一个字符
+			default:
+				t.escape = 0
+			}
+			continue
+		case 2:
+			if r >= 0x40 && r <= 0x7e {
+				t.escape = 0
+			}
+			continue
+		case 3:
+			if r == 0x07 {
+				t.escape = 0
+			} else if r == 0x1b {
+				t.escape = 4
+			}
+			continue
+		case 4:
+			t.escape = 0 //ESC \ 结束字符串序列
+			continue
+		case 5:
+			t.escape = 0
+			continue
+		}
+		switch r {
+		case 0x1b:
+			t.escape = 1
+		case '\r':
+			t.col = 0
+		case '\n':
+			t.w.WriteString(strings.TrimRight(string(t.line), " "))
+			t.w.WriteByte('\n')
+			t.line, t.col = t.line[:0], 0
+		case '\b':
+			if t.col > 0 {
+				t.col--
+			}
+		case '\t':
+			t.put(' ')
+			for t.col%8 != 0 {
+				t.put(' ')
+			}
+		default:
+			if r >= 0x20 && r != 0x7f {
+				t.put(r)
+			}
+		}
+	}
+}
+
+// 在光标位置写入字符，覆盖原有字符
+func (t *textWriter) put(r rune) {
+	if t.col < len(t.line) {
+		t.line[t.col] = r
+	} else {
+		for len(t.line) < t.col {
+			t.line = append(t.line, ' ')
+		}
+		t.line = append(t.line, r)
+	}
+	t.col++
+}
+
+// 写出最后一行
+func (t *textWriter) flush() error {
+	if len(t.line) > 0 {
+		t.w.WriteString(strings.TrimRight(string(t.line), " "))
+		t.w.WriteByte('\n')
+	}
+	return t.w.Flush()
+}
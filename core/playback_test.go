@@ -0,0 +1,139 @@
+// Package core : 核心包
+package core
+
+import (
+	"bufio"         //输出缓冲
+	"os"            //写入录像文件
+	"path/filepath" //录像路径
+	"strings"       //字符串库
+	"testing"       //测试
+)
+
+func TestTextWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"plain lines", []string{"hello\r\nworld\r\n"}, "hello\nworld\n"},
+		{"last line without newline", []string{"$ ls"}, "$ ls\n"},
+		{"carriage return overwrites", []string{"12345\rab\r\n"}, "ab345\n"},
+		{"backspace", []string{"abc\b\bX\r\n"}, "aXc\n"},
+		{"tab stops", []string{"a\tb\r\n"}, "a       b\n"},
+		{"csi color", []string{"\x1b[1;31mred\x1b[0m\r\n"}, "red\n"},
+		{"osc title with bel", []string{"\x1b]0;title\x07$ \r\n"}, "$\n"},
+		{"osc title with st", []string{"\x1b]0;title\x1b\\ok\r\n"}, "ok\n"},
+		{"charset designation", []string{"\x1b(Bok\r\n"}, "ok\n"},
+		{"escape split across events", []string{"a\x1b[3", "2mb\r\n"}, "ab\n"},
+		{"trailing spaces trimmed", []string{"a   \r\n"}, "a\n"},
+		{"control characters dropped", []string{"a\x00\x7fb\r\n"}, "ab\n"},
+		{"wide characters", []string{"中文\r\n"}, "中文\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			text := &textWriter{w: bufio.NewWriter(&out)}
+			for _, chunk := range tt.chunks {
+				text.write(chunk)
+			}
+			if err := text.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSeekCast(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { RecordDir = old }(RecordDir)
+	RecordDir = dir
+	cast := strings.Join([]string{
+		`{"version":2,"width":80,"height":24}`,
+		`[0.5,"o","a"]`,
+		`not json`,
+		`[1.0,"r","100x30"]`,
+		`[1.5,"o","b"]`,
+		`[2.0,"m","mark"]`,
+		`[3.0,"o","c"]`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "rec.cast"), []byte(cast), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		t          float64
+		data       string
+		cols, rows int
+		next       float64 //跳转后回放的第一个事件的时间，-1表示没有
+	}{
+		{"start", 0, "", 80, 24, 0.5},
+		{"before resize", 0.7, "a", 80, 24, 1.0},
+		{"after resize", 1.5, "ab", 100, 30, 2.0},
+		{"end", 10, "abc", 100, 30, -1},
+	}
+	defer func(old int) { TerminalBufferSize = old }(TerminalBufferSize)
+	TerminalBufferSize = 1024
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, reset, err := seekCast("rec", tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.close()
+			if reset.Type != "reset" || reset.Data != tt.data || reset.Cols != tt.cols || reset.Rows != tt.rows || reset.Time != tt.t {
+				t.Fatalf("reset = %+v", reset)
+			}
+			event, err := reader.next()
+			if tt.next < 0 {
+				if err == nil {
+					t.Fatalf("unexpected event %+v", event)
+				}
+				return
+			}
+			if err != nil || event.Time != tt.next {
+				t.Fatalf("next event = %+v, %v; want time %v", event, err, tt.next)
+			}
+		})
+	}
+}
+
+func TestSeekCastTail(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { RecordDir = old }(RecordDir)
+	RecordDir = dir
+	defer func(old int) { TerminalBufferSize = old }(TerminalBufferSize)
+	TerminalBufferSize = 8
+	cast := strings.Join([]string{
+		`{"version":2,"width":80,"height":24}`,
+		`[0.5,"o","0123456789"]`,
+		`[1.0,"o","中文"]`,
+		`[2.0,"o","xyz"]`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "rec.cast"), []byte(cast), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		t    float64
+		data string
+	}{
+		{"ascii tail", 0.5, "23456789"},
+		{"cut inside character", 1.0, "89中文"},
+		{"skips partial character", 2.0, "文xyz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, reset, err := seekCast("rec", tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader.close()
+			if reset.Data != tt.data {
+				t.Fatalf("reset data %q, want %q", reset.Data, tt.data)
+			}
+		})
+	}
+}
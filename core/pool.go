@@ -0,0 +1,228 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/sha256"           //连接池键
+	"encoding/hex"            //连接池键编码
+	"errors"                  //错误处理
+	"fmt"                     //格式化
+	"github.com/pkg/sftp"     //sftp包
+	"golang.org/x/crypto/ssh" //ssh库
+	"sync"                    //互斥锁
+	"time"                    //时间日期库
+)
+
+// PoolIdleTimeout 没有会话使用的SSH连接保留多久，期间重新登录同一主机不需要再次握手认证
+// 为0时最后一个使用者释放后立即关闭
+var PoolIdleTimeout = 5 * time.Minute
+
+// 连接池中的SSH连接，终端与文件管理通过它复用同一条连接及同一个sftp客户端
+type pooledConn struct {
+	key       string        //连接池键
+	client    *SSHClient    //建立连接的SSH客户端，持有SSH连接及跳板机连接
+	mu        sync.Mutex    //保护sftp及client
+	sftp      *sftp.Client  //共享的sftp客户端，第一次文件操作时创建
+	refs      int           //引用计数，会话及正在进行的终端、文件操作各占一个
+	private   bool          //认证时转发过键盘交互问题，只属于建立它的会话，空闲时立即关闭
+	idleSince time.Time     //引用计数归零的时间
+	done      chan struct{} //SSH连接断开时关闭
+}
+
+// ConnPool 按主机、用户及认证信息复用SSH连接
+type ConnPool struct {
+	mu    sync.Mutex             //保护conns
+	conns map[string]*pooledConn //连接池键到连接的映射
+	once  sync.Once              //只启动一次空闲清理
+}
+
+// Pool 全局SSH连接池
+var Pool = &ConnPool{conns: make(map[string]*pooledConn)}
+
+// 计算连接池键，包含web用户及全部认证信息，不同用户或不同凭据之间不会共享连接
+// 验证码等转发给浏览器的回答不在键中，这样建立的连接由Get另加随机后缀，不会被复用
+func poolKey(sclient *SSHClient) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q", sclient.WebUser)
+	for _, hop := range append([]SSHClient{*sclient}, sclient.JumpHosts...) {
+		fmt.Fprintf(hash, "|%q %q %d %d %q %q %q %q %q", hop.Username, hop.IPAddress, hop.Port, hop.LoginType,
+			hop.Password, hop.Passphrase, hop.Certificate, hop.Proxy, hop.Algorithms)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get 取可复用的SSH连接，没有时新建连接，引用计数加一
+// 设置了Challenge时总是新建连接，认证依赖本次转发的回答，不能让相同凭据的其他会话跳过
+// sclient : 连接信息，新建连接时写回主机密钥校验结果及协商算法
+func (p *ConnPool) Get(sclient *SSHClient) (*pooledConn, error) {
+	p.once.Do(func() { go p.expireLoop() })
+	key := poolKey(sclient)
+	private := sclient.Challenge != nil
+	if private {
+		nonce, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		key += "|" + nonce
+	}
+	p.mu.Lock()
+	if conn, ok := p.conns[key]; ok {
+		conn.refs++
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+	//用副本建立连接，sclient只保存连接信息
+	dialer := *sclient
+	dialer.JumpHosts = append([]SSHClient(nil), sclient.JumpHosts...)
+	err := dialer.GenerateClient()
+	dialer.Challenge = nil //问题转发只在本次认证中使用
+	sclient.copyResults(&dialer)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	//并发新建了同一连接时使用先建立的连接
+	if conn, ok := p.conns[key]; ok {
+		dialer.Close()
+		conn.refs++
+		return conn, nil
+	}
+	conn := &pooledConn{key: key, client: &dialer, refs: 1, private: private, done: make(chan struct{})}
+	p.conns[key] = conn
+	go p.watch(conn, dialer.Client)
+	return conn, nil
+}
+
+// Ref 为正在进行的终端或文件操作增加引用
+func (p *ConnPool) Ref(conn *pooledConn) {
+	p.mu.Lock()
+	conn.refs++
+	p.mu.Unlock()
+}
+
+// Put 释放引用，引用计数归零后连接进入空闲状态
+func (p *ConnPool) Put(conn *pooledConn) {
+	p.mu.Lock()
+	conn.refs--
+	idle := conn.refs == 0
+	if idle {
+		conn.idleSince = time.Now()
+	}
+	closeNow := idle && (PoolIdleTimeout <= 0 || conn.private) && p.conns[conn.key] == conn
+	if closeNow {
+		delete(p.conns, conn.key)
+	}
+	p.mu.Unlock()
+	if closeNow {
+		conn.close()
+	}
+}
+
+// SSH连接断开时从连接池中删除
+// client : 连接的SSH客户端，close会清空conn.client.Client，不能在这里读取
+func (p *ConnPool) watch(conn *pooledConn, client *ssh.Client) {
+	client.Wait()
+	p.mu.Lock()
+	if p.conns[conn.key] == conn {
+		delete(p.conns, conn.key)
+	}
+	p.mu.Unlock()
+	conn.close()
+	close(conn.done)
+}
+
+// 定时关闭空闲超时的连接
+func (p *ConnPool) expireLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var expired []*pooledConn
+		p.mu.Lock()
+		for key, conn := range p.conns {
+			if conn.refs == 0 && now.Sub(conn.idleSince) >= PoolIdleTimeout {
+				delete(p.conns, key)
+				expired = append(expired, conn)
+			}
+		}
+		p.mu.Unlock()
+		for _, conn := range expired {
+			conn.close()
+		}
+	}
+}
+
+// 关闭sftp客户端及SSH连接
+func (conn *pooledConn) close() {
+	conn.mu.Lock()
+	if conn.sftp != nil {
+		conn.sftp.Close()
+		conn.sftp = nil
+	}
+	conn.client.Close()
+	conn.mu.Unlock()
+}
+
+// 取共享的sftp客户端，第一次使用时创建
+func (conn *pooledConn) sftpClient() (*sftp.Client, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.client.Client == nil {
+		return nil, errors.New("ssh connection closed")
+	}
+	if conn.sftp == nil {
+		client, err := sftp.NewClient(conn.client.Client)
+		if err != nil {
+			return nil, err
+		}
+		conn.sftp = client
+	}
+	return conn.sftp, nil
+}
+
+// 生成与连接池共享SSH连接的客户端副本，Close时不会关闭共享的连接
+func (conn *pooledConn) shared() (*SSHClient, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.client.Client == nil {
+		return nil, &CodeError{Code: CodeSessionNotFound, Err: errors.New("ssh connection closed")}
+	}
+	shared := *conn.client
+	shared.shared = true
+	shared.pool = conn
+	shared.Sftp, shared.Session, shared.StdinPipe = nil, nil, nil
+	shared.JumpHosts = append([]SSHClient(nil), conn.client.JumpHosts...)
+	//首次记录主机密钥的提示只显示一次
+	conn.client.HostKey.Added = false
+	for i := range conn.client.JumpHosts {
+		conn.client.JumpHosts[i].HostKey.Added = false
+	}
+	return &shared, nil
+}
+
+// 共享的SSH连接断开时关闭的信道，未共享连接时返回空信道，永远不会关闭
+func (sclient *SSHClient) connDone() <-chan struct{} {
+	if sclient.pool == nil {
+		return nil
+	}
+	return sclient.pool.done
+}
+
+// 连接信息快照，用于返回主机密钥及协商算法
+func (conn *pooledConn) snapshot() *SSHClient {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	snapshot := *conn.client
+	snapshot.JumpHosts = append([]SSHClient(nil), conn.client.JumpHosts...)
+	return &snapshot
+}
+
+// 复制主机密钥校验结果及协商算法
+func (sclient *SSHClient) copyResults(from *SSHClient) {
+	sclient.HostKey = from.HostKey
+	sclient.Negotiated = from.Negotiated
+	for i := range sclient.JumpHosts {
+		sclient.JumpHosts[i].HostKey = from.JumpHosts[i].HostKey
+		sclient.JumpHosts[i].Negotiated = from.JumpHosts[i].Negotiated
+	}
+}
@@ -0,0 +1,195 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/ed25519"          //主机密钥
+	"crypto/rand"             //随机数
+	"golang.org/x/crypto/ssh" //ssh库
+	"io"                      //转发
+	"net"                     //监听
+	"strconv"                 //端口转换
+	"sync/atomic"             //计数
+	"testing"                 //测试
+)
+
+// 启动测试用SSH服务器，返回监听的主机、端口及完成认证的连接数
+// config : 服务端认证配置，主机密钥由本函数生成
+func startTestSSHServer(t *testing.T, config *ssh.ServerConfig) (string, int, *atomic.Int64) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var logins atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				logins.Add(1)
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					if ch.ChannelType() != "direct-tcpip" {
+						ch.Reject(ssh.Prohibited, "test server")
+						continue
+					}
+					go forwardTestChannel(ch)
+				}
+				sshConn.Close()
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum, &logins
+}
+
+// 作为跳板机转发direct-tcpip通道
+func forwardTestChannel(newCh ssh.NewChannel) {
+	var target struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &target); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(conn, ch)
+		conn.Close()
+	}()
+	io.Copy(ch, conn)
+	ch.Close()
+}
+
+// 测试期间不校验主机密钥
+func acceptAllHostKeys(t *testing.T) {
+	old := HostKeys
+	HostKeys = &KnownHosts{policy: HostKeyAcceptAll}
+	t.Cleanup(func() { HostKeys = old })
+}
+
+// 只接受验证码123456的键盘交互认证
+func otpServerConfig() *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != "123456" {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+}
+
+func TestPoolKeyboardInteractiveNotShared(t *testing.T) {
+	acceptAllHostKeys(t)
+	host, port, logins := startTestSSHServer(t, otpServerConfig())
+	pool := &ConnPool{conns: make(map[string]*pooledConn)}
+	var asked atomic.Int64
+	answer := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		asked.Add(1)
+		return []string{"123456"}, nil
+	}
+	newClient := func() *SSHClient {
+		return &SSHClient{Username: "u", IPAddress: host, Port: port, LoginType: LoginKeyboardInteractive}
+	}
+
+	first := newClient()
+	first.Challenge = answer
+	conn1, err := pool.Get(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//相同web用户及登录信息的第二个会话必须重新回答问题
+	second := newClient()
+	second.Challenge = answer
+	conn2, err := pool.Get(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(conn2)
+	if conn1 == conn2 {
+		t.Fatal("second keyboard-interactive session reused the first connection")
+	}
+	if asked.Load() != 2 || logins.Load() != 2 {
+		t.Fatalf("challenges %d, logins %d, want 2 each", asked.Load(), logins.Load())
+	}
+
+	//不能转发问题的请求不能复用已认证的连接
+	if _, err := pool.Get(newClient()); ErrorCode(err) != CodeInteractiveRequired {
+		t.Fatalf("got %v, want %s", err, CodeInteractiveRequired)
+	}
+
+	//会话释放后连接立即关闭，不进入空闲复用
+	pool.Put(conn1)
+	pool.mu.Lock()
+	_, kept := pool.conns[conn1.key]
+	pool.mu.Unlock()
+	if kept {
+		t.Fatal("idle keyboard-interactive connection kept in pool")
+	}
+}
+
+func TestPoolPasswordShared(t *testing.T) {
+	acceptAllHostKeys(t)
+	host, port, logins := startTestSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "pass" {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	})
+	pool := &ConnPool{conns: make(map[string]*pooledConn)}
+	newClient := func() *SSHClient {
+		return &SSHClient{Username: "u", IPAddress: host, Port: port, Password: "pass"}
+	}
+	conn1, err := pool.Get(newClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(conn1)
+	conn2, err := pool.Get(newClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(conn2)
+	if conn1 != conn2 || logins.Load() != 1 {
+		t.Fatalf("password sessions not shared, logins %d", logins.Load())
+	}
+}
@@ -0,0 +1,360 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"                      //字节操作
+	"crypto/aes"                 //AES解密
+	"crypto/cipher"              //CBC模式
+	"crypto/dsa"                 //DSA密钥
+	"crypto/ecdsa"               //ECDSA密钥
+	"crypto/ed25519"             //Ed25519密钥
+	"crypto/elliptic"            //椭圆曲线
+	"crypto/hmac"                //HMAC校验
+	"crypto/rsa"                 //RSA密钥
+	"crypto/sha1"                //SHA1哈希
+	"crypto/sha256"              //SHA256哈希
+	"crypto/x509"                //错误的密码短语
+	"encoding/base64"            //base64解码
+	"encoding/hex"               //十六进制解码
+	"errors"                     //错误处理
+	"fmt"                        //格式化
+	"golang.org/x/crypto/argon2" //PPK v3密钥派生
+	"golang.org/x/crypto/ssh"    //ssh库
+	"hash"                       //哈希接口
+	"math/big"                   //大整数
+	"strconv"                    //字符串转换
+	"strings"                    //字符串库
+)
+
+// 私钥相关错误码
+const (
+	CodePassphraseRequired  = "PASSPHRASE_REQUIRED"  //私钥已加密，需要密码短语
+	CodePassphraseIncorrect = "PASSPHRASE_INCORRECT" //密码短语错误
+	CodeKeyInvalid          = "KEY_INVALID"          //无法解析的私钥
+)
+
+// PuTTY私钥文件头
+const ppkHeader = "PuTTY-User-Key-File-"
+
+// PPK v3允许的Argon2参数上限，参数来自上传的文件，过大时解析一次就会耗尽内存或CPU
+// PuTTYgen默认为8MB内存、1个线程，迭代次数按耗时自动调整
+const (
+	ppkArgon2MaxMemory      = 1 << 20 //最大内存(KB)，1GB
+	ppkArgon2MaxPasses      = 1000    //最大迭代次数
+	ppkArgon2MaxParallelism = 16      //最大并行度
+)
+
+// ParsePrivateKey 解析私钥并返回签名器
+// 支持OpenSSH/PEM格式(可加密)及PuTTY PPK v2/v3格式
+// key : 私钥内容
+// passphrase : 密码短语，私钥未加密时为空
+func ParsePrivateKey(key, passphrase string) (ssh.Signer, error) {
+	//PuTTY格式私钥在服务端转换
+	if strings.HasPrefix(strings.TrimSpace(key), ppkHeader) {
+		return parsePPK(strings.TrimSpace(key), passphrase)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	var missingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &missingErr) {
+		if err != nil {
+			return nil, &CodeError{Code: CodeKeyInvalid, Err: err}
+		}
+		return signer, nil
+	}
+	//私钥已加密
+	if passphrase == "" {
+		return nil, &CodeError{Code: CodePassphraseRequired, Err: errors.New("private key is encrypted, passphrase required")}
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, &CodeError{Code: CodePassphraseIncorrect, Err: errors.New("incorrect passphrase for private key")}
+	}
+	if err != nil {
+		return nil, &CodeError{Code: CodeKeyInvalid, Err: err}
+	}
+	return signer, nil
+}
+
+// PuTTY私钥文件内容
+type ppkFile struct {
+	version    int               //版本号 2或3
+	algorithm  string            //密钥算法
+	headers    map[string]string //头部字段
+	publicBlob []byte            //公钥数据
+	privBlob   []byte            //私钥数据(可能已加密)
+}
+
+// 解析PuTTY PPK格式私钥
+func parsePPK(content, passphrase string) (ssh.Signer, error) {
+	ppk, err := readPPK(content)
+	if err != nil {
+		return nil, &CodeError{Code: CodeKeyInvalid, Err: err}
+	}
+	encryption := ppk.headers["Encryption"]
+	if encryption != "none" && encryption != "aes256-cbc" {
+		return nil, &CodeError{Code: CodeKeyInvalid, Err: fmt.Errorf("ppk: unsupported encryption %s", encryption)}
+	}
+	if encryption != "none" && passphrase == "" {
+		return nil, &CodeError{Code: CodePassphraseRequired, Err: errors.New("private key is encrypted, passphrase required")}
+	}
+	if encryption == "none" {
+		passphrase = "" //未加密的文件用空密码计算MAC
+	}
+	//派生解密密钥、IV及MAC密钥
+	var (
+		cipherKey, iv, macKey []byte
+		newHash               func() hash.Hash
+	)
+	if ppk.version == 2 {
+		cipherKey = ppkV2CipherKey(passphrase)
+		iv = make([]byte, aes.BlockSize)
+		macKeyHash := sha1.Sum([]byte("putty-private-key-file-mac-key" + passphrase))
+		macKey, newHash = macKeyHash[:], sha1.New
+	} else {
+		if cipherKey, iv, macKey, err = ppkV3Keys(ppk.headers, passphrase); err != nil {
+			return nil, &CodeError{Code: CodeKeyInvalid, Err: err}
+		}
+		newHash = sha256.New
+	}
+	privBlob := ppk.privBlob
+	if encryption != "none" {
+		if len(privBlob)%aes.BlockSize != 0 {
+			return nil, &CodeError{Code: CodeKeyInvalid, Err: errors.New("ppk: invalid private blob length")}
+		}
+		block, _ := aes.NewCipher(cipherKey)
+		decrypted := make([]byte, len(privBlob))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, privBlob)
+		privBlob = decrypted
+	}
+	//校验MAC，加密文件MAC不一致说明密码短语错误
+	macData := ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{ppk.algorithm, encryption, ppk.headers["Comment"], ppk.publicBlob, privBlob})
+	mac := hmac.New(newHash, macKey)
+	mac.Write(macData)
+	want, err := hex.DecodeString(ppk.headers["Private-MAC"])
+	if err != nil || !hmac.Equal(mac.Sum(nil), want) {
+		if encryption != "none" {
+			return nil, &CodeError{Code: CodePassphraseIncorrect, Err: errors.New("incorrect passphrase for private key")}
+		}
+		return nil, &CodeError{Code: CodeKeyInvalid, Err: errors.New("ppk: MAC verification failed, file is corrupted")}
+	}
+	privateKey, err := ppkPrivateKey(ppk.algorithm, ppk.publicBlob, privBlob)
+	if err != nil {
+		return nil, &CodeError{Code: CodeKeyInvalid, Err: err}
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, &CodeError{Code: CodeKeyInvalid, Err: err}
+	}
+	return signer, nil
+}
+
+// 按行读取PPK文件的头部字段与公私钥数据
+func readPPK(content string) (*ppkFile, error) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	ppk := &ppkFile{headers: make(map[string]string)}
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		name, value, found := strings.Cut(line, ": ")
+		if !found {
+			return nil, fmt.Errorf("ppk: malformed line %d", i+1)
+		}
+		switch {
+		case strings.HasPrefix(name, ppkHeader):
+			version, err := strconv.Atoi(strings.TrimPrefix(name, ppkHeader))
+			if err != nil || (version != 2 && version != 3) {
+				return nil, fmt.Errorf("ppk: unsupported version %s", name)
+			}
+			ppk.version, ppk.algorithm = version, value
+		case name == "Public-Lines" || name == "Private-Lines":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 0 || i+count >= len(lines) {
+				return nil, fmt.Errorf("ppk: invalid %s", name)
+			}
+			//多行base64数据
+			var encoded strings.Builder
+			for j := 0; j < count; j++ {
+				encoded.WriteString(strings.TrimSpace(lines[i+1+j]))
+			}
+			data, err := base64.StdEncoding.DecodeString(encoded.String())
+			if err != nil {
+				return nil, fmt.Errorf("ppk: invalid %s: %v", name, err)
+			}
+			if name == "Public-Lines" {
+				ppk.publicBlob = data
+			} else {
+				ppk.privBlob = data
+			}
+			i += count
+		default:
+			ppk.headers[name] = value
+		}
+	}
+	if ppk.version == 0 || ppk.publicBlob == nil || ppk.privBlob == nil {
+		return nil, errors.New("ppk: incomplete key file")
+	}
+	return ppk, nil
+}
+
+// PPK v2的AES密钥: SHA1(0,passphrase) || SHA1(1,passphrase) 取前32字节
+func ppkV2CipherKey(passphrase string) []byte {
+	var key []byte
+	for seq := byte(0); seq < 2; seq++ {
+		digest := sha1.Sum(append([]byte{0, 0, 0, seq}, passphrase...))
+		key = append(key, digest[:]...)
+	}
+	return key[:32]
+}
+
+// PPK v3使用Argon2派生AES密钥、IV及MAC密钥
+func ppkV3Keys(headers map[string]string, passphrase string) (cipherKey, iv, macKey []byte, err error) {
+	if headers["Encryption"] == "none" {
+		return nil, nil, []byte{}, nil //未加密时MAC密钥为空
+	}
+	memory, err1 := strconv.ParseUint(headers["Argon2-Memory"], 10, 32)
+	passes, err2 := strconv.ParseUint(headers["Argon2-Passes"], 10, 32)
+	parallelism, err3 := strconv.ParseUint(headers["Argon2-Parallelism"], 10, 8)
+	salt, err4 := hex.DecodeString(headers["Argon2-Salt"])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, nil, nil, fmt.Errorf("ppk: invalid argon2 parameters: %v", err)
+	}
+	//迭代次数或并行度为0时argon2会panic
+	if passes < 1 || passes > ppkArgon2MaxPasses || parallelism < 1 || parallelism > ppkArgon2MaxParallelism ||
+		memory > ppkArgon2MaxMemory {
+		return nil, nil, nil, fmt.Errorf("ppk: argon2 parameters out of range (memory %d, passes %d, parallelism %d)",
+			memory, passes, parallelism)
+	}
+	var derived []byte
+	switch headers["Key-Derivation"] {
+	case "Argon2id":
+		derived = argon2.IDKey([]byte(passphrase), salt, uint32(passes), uint32(memory), uint8(parallelism), 80)
+	case "Argon2i":
+		derived = argon2.Key([]byte(passphrase), salt, uint32(passes), uint32(memory), uint8(parallelism), 80)
+	default:
+		return nil, nil, nil, fmt.Errorf("ppk: unsupported key derivation %s", headers["Key-Derivation"])
+	}
+	return derived[:32], derived[32:48], derived[48:], nil
+}
+
+// 按算法从PPK公私钥数据构造私钥
+func ppkPrivateKey(algorithm string, publicBlob, privBlob []byte) (interface{}, error) {
+	switch algorithm {
+	case ssh.KeyAlgoRSA:
+		var pub struct {
+			Name string
+			E    *big.Int
+			N    *big.Int
+		}
+		var priv struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := unmarshalPPK(publicBlob, &pub, privBlob, &priv); err != nil {
+			return nil, err
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: pub.N, E: int(pub.E.Int64())},
+			D:         priv.D,
+			Primes:    []*big.Int{priv.P, priv.Q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	case ssh.KeyAlgoDSA:
+		var pub struct {
+			Name string
+			P    *big.Int
+			Q    *big.Int
+			G    *big.Int
+			Y    *big.Int
+		}
+		var priv struct {
+			X    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := unmarshalPPK(publicBlob, &pub, privBlob, &priv); err != nil {
+			return nil, err
+		}
+		return &dsa.PrivateKey{
+			PublicKey: dsa.PublicKey{Parameters: dsa.Parameters{P: pub.P, Q: pub.Q, G: pub.G}, Y: pub.Y},
+			X:         priv.X,
+		}, nil
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		var pub struct {
+			Name  string
+			Curve string
+			Point []byte
+		}
+		var priv struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := unmarshalPPK(publicBlob, &pub, privBlob, &priv); err != nil {
+			return nil, err
+		}
+		var curve elliptic.Curve
+		switch pub.Curve {
+		case "nistp256":
+			curve = elliptic.P256()
+		case "nistp384":
+			curve = elliptic.P384()
+		case "nistp521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ppk: unsupported curve %s", pub.Curve)
+		}
+		x, y := elliptic.Unmarshal(curve, pub.Point)
+		if x == nil {
+			return nil, errors.New("ppk: invalid ecdsa public point")
+		}
+		return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: priv.D}, nil
+	case ssh.KeyAlgoED25519:
+		var pub struct {
+			Name string
+			Key  []byte
+		}
+		var priv struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := unmarshalPPK(publicBlob, &pub, privBlob, &priv); err != nil {
+			return nil, err
+		}
+		//PuTTY把种子按小端无符号整数保存，末尾为0的字节被省略，编码可能短于32字节
+		if len(priv.Key) > ed25519.SeedSize {
+			return nil, errors.New("ppk: invalid ed25519 private key")
+		}
+		seed := make([]byte, ed25519.SeedSize)
+		copy(seed, priv.Key)
+		key := ed25519.NewKeyFromSeed(seed)
+		if !bytes.Equal(key.Public().(ed25519.PublicKey), pub.Key) {
+			return nil, errors.New("ppk: ed25519 public key does not match private key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("ppk: unsupported key algorithm %s", algorithm)
+}
+
+// 反序列化PPK公私钥数据
+func unmarshalPPK(publicBlob []byte, pub interface{}, privBlob []byte, priv interface{}) error {
+	if err := ssh.Unmarshal(publicBlob, pub); err != nil {
+		return fmt.Errorf("ppk: invalid public key: %v", err)
+	}
+	if err := ssh.Unmarshal(privBlob, priv); err != nil {
+		return fmt.Errorf("ppk: invalid private key: %v", err)
+	}
+	return nil
+}
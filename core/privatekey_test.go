@@ -0,0 +1,212 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"                      //字节操作
+	"crypto/aes"                 //AES加密
+	"crypto/cipher"              //CBC模式
+	"crypto/ecdsa"               //ECDSA密钥
+	"crypto/ed25519"             //Ed25519密钥
+	"crypto/elliptic"            //椭圆曲线
+	"crypto/hmac"                //MAC计算
+	"crypto/rand"                //随机密钥
+	"crypto/rsa"                 //RSA密钥
+	"crypto/sha1"                //SHA1哈希
+	"crypto/sha256"              //SHA256哈希
+	"encoding/base64"            //base64编码
+	"encoding/hex"               //十六进制编码
+	"fmt"                        //格式化
+	"golang.org/x/crypto/argon2" //PPK v3密钥派生
+	"golang.org/x/crypto/ssh"    //ssh库
+	"hash"                       //哈希接口
+	"math/big"                   //大整数
+	"strings"                    //字符串库
+	"testing"                    //测试
+)
+
+// 测试用PPK文件参数
+type ppkSpec struct {
+	version    int    //2或3
+	algorithm  string //密钥算法
+	publicBlob []byte //公钥数据
+	privBlob   []byte //未加密的私钥数据
+	passphrase string //为空时不加密
+	badMAC     bool   //写入错误的MAC
+	argon2     string //替换v3的Argon2参数头，格式为"内存 迭代次数 并行度"
+}
+
+// 按PuTTY的格式生成PPK文件
+func buildPPK(spec ppkSpec) string {
+	encryption := "none"
+	headers := ""
+	privBlob := spec.privBlob
+	var cipherKey, iv, macKey []byte
+	var newHash func() hash.Hash
+	if spec.passphrase != "" {
+		encryption = "aes256-cbc"
+		//加密时按块大小填充
+		if pad := len(privBlob) % aes.BlockSize; pad != 0 {
+			privBlob = append(append([]byte(nil), privBlob...), make([]byte, aes.BlockSize-pad)...)
+		}
+	}
+	if spec.version == 2 {
+		cipherKey, iv = ppkV2CipherKey(spec.passphrase), make([]byte, aes.BlockSize)
+		sum := sha1.Sum([]byte("putty-private-key-file-mac-key" + spec.passphrase))
+		macKey, newHash = sum[:], sha1.New
+	} else {
+		newHash, macKey = sha256.New, []byte{}
+		if spec.passphrase != "" {
+			salt := []byte("0123456789abcdef")
+			params := [3]string{"64", "1", "1"}
+			if spec.argon2 != "" {
+				copy(params[:], strings.Fields(spec.argon2))
+			}
+			headers = fmt.Sprintf("Key-Derivation: Argon2id\nArgon2-Memory: %s\nArgon2-Passes: %s\nArgon2-Parallelism: %s\nArgon2-Salt: %s\n",
+				params[0], params[1], params[2], hex.EncodeToString(salt))
+			derived := argon2.IDKey([]byte(spec.passphrase), salt, 1, 64, 1, 80)
+			cipherKey, iv, macKey = derived[:32], derived[32:48], derived[48:]
+		}
+	}
+	macData := ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{spec.algorithm, encryption, "test", spec.publicBlob, privBlob})
+	mac := hmac.New(newHash, macKey)
+	mac.Write(macData)
+	sum := mac.Sum(nil)
+	if spec.badMAC {
+		sum[0] ^= 0xff
+	}
+	if encryption != "none" {
+		block, _ := aes.NewCipher(cipherKey)
+		encrypted := make([]byte, len(privBlob))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, privBlob)
+		privBlob = encrypted
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "PuTTY-User-Key-File-%d: %s\nEncryption: %s\nComment: test\n", spec.version, spec.algorithm, encryption)
+	writeLines := func(name string, data []byte) {
+		encoded := base64.StdEncoding.EncodeToString(data)
+		var lines []string
+		for len(encoded) > 64 {
+			lines, encoded = append(lines, encoded[:64]), encoded[64:]
+		}
+		lines = append(lines, encoded)
+		fmt.Fprintf(&b, "%s: %d\n%s\n", name, len(lines), strings.Join(lines, "\n"))
+	}
+	writeLines("Public-Lines", spec.publicBlob)
+	b.WriteString(headers)
+	writeLines("Private-Lines", privBlob)
+	fmt.Fprintf(&b, "Private-MAC: %s\n", hex.EncodeToString(sum))
+	return b.String()
+}
+
+// ed25519公私钥数据，私钥按PuTTY的小端无符号整数编码，省略末尾为0的字节
+func ed25519Blobs(seed []byte) (ssh.PublicKey, []byte, []byte) {
+	key := ed25519.NewKeyFromSeed(seed)
+	pub, _ := ssh.NewPublicKey(key.Public())
+	return pub, pub.Marshal(), ssh.Marshal(struct{ Key []byte }{bytes.TrimRight(seed, "\x00")})
+}
+
+func TestParsePPK(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	edPub, edPublicBlob, edPrivBlob := ed25519Blobs(seed)
+	//种子高位字节为0，PuTTY的编码短于32字节
+	shortSeed := append(bytes.Repeat([]byte{9}, ed25519.SeedSize-2), 0, 0)
+	shortPub, shortPublicBlob, shortPrivBlob := ed25519Blobs(shortSeed)
+	if len(shortPrivBlob) != 4+ed25519.SeedSize-2 {
+		t.Fatalf("short ed25519 blob is %d bytes", len(shortPrivBlob))
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub, _ := ssh.NewPublicKey(&ecKey.PublicKey)
+	ecPrivBlob := ssh.Marshal(struct{ D *big.Int }{ecKey.D})
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, _ := ssh.NewPublicKey(&rsaKey.PublicKey)
+	rsaPrivBlob := ssh.Marshal(struct {
+		D, P, Q, Iqmp *big.Int
+	}{rsaKey.D, rsaKey.Primes[0], rsaKey.Primes[1], rsaKey.Precomputed.Qinv})
+
+	tests := []struct {
+		name       string
+		spec       ppkSpec
+		passphrase string        //解析时使用的密码短语
+		want       ssh.PublicKey //解析成功时的公钥
+		code       string        //解析失败时的错误码
+	}{
+		{"v2 unencrypted ed25519", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob}, "", edPub, ""},
+		{"v2 unencrypted ignores passphrase", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob}, "unused", edPub, ""},
+		{"v2 encrypted ecdsa", ppkSpec{version: 2, algorithm: ssh.KeyAlgoECDSA256, publicBlob: ecPub.Marshal(), privBlob: ecPrivBlob, passphrase: "secret"}, "secret", ecPub, ""},
+		{"v2 encrypted rsa", ppkSpec{version: 2, algorithm: ssh.KeyAlgoRSA, publicBlob: rsaPub.Marshal(), privBlob: rsaPrivBlob, passphrase: "secret"}, "secret", rsaPub, ""},
+		{"v2 passphrase required", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret"}, "", nil, CodePassphraseRequired},
+		{"v2 wrong passphrase", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret"}, "wrong", nil, CodePassphraseIncorrect},
+		{"v2 bad MAC", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, badMAC: true}, "", nil, CodeKeyInvalid},
+		{"v3 unencrypted ecdsa", ppkSpec{version: 3, algorithm: ssh.KeyAlgoECDSA256, publicBlob: ecPub.Marshal(), privBlob: ecPrivBlob}, "", ecPub, ""},
+		{"v3 encrypted ed25519", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret"}, "secret", edPub, ""},
+		{"v3 wrong passphrase", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret"}, "wrong", nil, CodePassphraseIncorrect},
+		{"v3 bad MAC", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, badMAC: true}, "", nil, CodeKeyInvalid},
+		{"v2 short ed25519", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: shortPublicBlob, privBlob: shortPrivBlob}, "", shortPub, ""},
+		{"v3 encrypted short ed25519", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: shortPublicBlob, privBlob: shortPrivBlob, passphrase: "secret"}, "secret", shortPub, ""},
+		{"v3 argon2 zero passes", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret", argon2: "64 0 1"}, "secret", nil, CodeKeyInvalid},
+		{"v3 argon2 zero parallelism", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret", argon2: "64 1 0"}, "secret", nil, CodeKeyInvalid},
+		{"v3 argon2 huge memory", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret", argon2: "4294967295 1 1"}, "secret", nil, CodeKeyInvalid},
+		{"v3 argon2 too many passes", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret", argon2: "64 4294967295 1"}, "secret", nil, CodeKeyInvalid},
+		{"v3 argon2 too much parallelism", ppkSpec{version: 3, algorithm: ssh.KeyAlgoED25519, publicBlob: edPublicBlob, privBlob: edPrivBlob, passphrase: "secret", argon2: "64 1 255"}, "secret", nil, CodeKeyInvalid},
+		{"ed25519 public key mismatch", ppkSpec{version: 2, algorithm: ssh.KeyAlgoED25519, publicBlob: shortPublicBlob, privBlob: edPrivBlob}, "", nil, CodeKeyInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := ParsePrivateKey(buildPPK(tt.spec), tt.passphrase)
+			if tt.code != "" {
+				if ErrorCode(err) != tt.code {
+					t.Fatalf("got error %v (code %q), want code %s", err, ErrorCode(err), tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), tt.want.Marshal()) {
+				t.Fatal("public key does not match")
+			}
+			//签名可以用原公钥验证
+			sig, err := signer.Sign(rand.Reader, []byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.want.Verify([]byte("data"), sig); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReadPPKMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unsupported version", "PuTTY-User-Key-File-1: ssh-rsa\nPublic-Lines: 0\n\nPrivate-Lines: 0\n\n"},
+		{"line count past end", "PuTTY-User-Key-File-2: ssh-ed25519\nPublic-Lines: 5\nAAAA\n"},
+		{"negative line count", "PuTTY-User-Key-File-2: ssh-ed25519\nPublic-Lines: -1\n"},
+		{"missing private lines", "PuTTY-User-Key-File-2: ssh-ed25519\nPublic-Lines: 1\nAAAA\n"},
+		{"malformed line", "PuTTY-User-Key-File-2: ssh-ed25519\ngarbage\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readPPK(tt.content); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Package core : 核心包
+package core
+
+import (
+	"encoding/json"                //控制消息编码
+	"fmt"                          //格式化
+	"github.com/gorilla/websocket" //websocket库
+	"regexp"                       //旧协议resize消息匹配
+	"strconv"                      //字符串转换库
+	"sync"                         //发送队列
+)
+
+// 终端websocket协议版本，连接时由查询参数protocol指定
+const (
+	// ProtocolLegacy 旧协议，全部为文本消息，浏览器发送的"ping"及"resize:行:列"以外的消息均为输入
+	ProtocolLegacy = 1
+	// ProtocolV2 二进制消息为输入及输出字节流，文本消息为JSON控制消息
+	ProtocolV2 = 2
+)
+
+// 控制消息类型
+const (
+	MessageInput  = "input"  //输入，data为输入内容
+	MessageResize = "resize" //调整终端大小，rows、cols
+	MessagePing   = "ping"   //心跳，服务端回复pong
+	MessagePong   = "pong"   //心跳回复
+	MessageSignal = "signal" //向shell发送信号，signal为信号名
+	MessageNotice = "notice" //服务端提示，message为带颜色控制符的文本
+	MessageError  = "error"  //浏览器发送的控制消息无效，message为原因
+)
+
+// 旧协议的resize消息，必须完整匹配
+var legacyResize = regexp.MustCompile(`^resize:(\d+):(\d+)$`)
+
+// 允许浏览器发送的信号
+var terminalSignals = map[string]bool{
+	"INT": true, "TERM": true, "KILL": true, "HUP": true, "QUIT": true,
+	"USR1": true, "USR2": true, "ABRT": true, "ALRM": true,
+}
+
+// ControlMessage 协议v2的JSON控制消息
+type ControlMessage struct {
+	Type    string `json:"type"`              //消息类型
+	Data    string `json:"data,omitempty"`    //input的输入内容
+	Rows    int    `json:"rows,omitempty"`    //resize的行数
+	Cols    int    `json:"cols,omitempty"`    //resize的列数
+	Signal  string `json:"signal,omitempty"`  //signal的信号名，如INT、TERM、KILL
+	Message string `json:"message,omitempty"` //notice、error的内容
+}
+
+// ParseProtocol 解析查询参数protocol，未指定或无法识别时为旧协议
+func ParseProtocol(value string) int {
+	if version, _ := strconv.Atoi(value); version == ProtocolV2 {
+		return ProtocolV2
+	}
+	return ProtocolLegacy
+}
+
+// TermConn 终端websocket连接，按协议版本编码输出、提示及控制消息
+// 全部消息经发送队列由独立的协程写入，浏览器读取慢时相邻的输出在队列中合并
+type TermConn struct {
+	*websocket.Conn               //websocket连接
+	Protocol        int           //协议版本
+	output          *wsOutput     //旧协议的输出，保留跨写入的不完整字符
+	mu              sync.Mutex    //保护以下字段
+	cond            *sync.Cond    //队列变化
+	frames          []outFrame    //发送队列
+	queued          int           //排队及正在发送的输出字节数
+	err             error         //连接关闭或写入失败后不再接受消息
+	done            chan struct{} //发送协程退出时关闭
+}
+
+// NewTermConn 创建终端websocket连接并启动发送协程
+// protocol : 协议版本，见ParseProtocol
+func NewTermConn(ws *websocket.Conn, protocol int) *TermConn {
+	conn := &TermConn{Conn: ws, Protocol: protocol, output: &wsOutput{ws: ws}, done: make(chan struct{})}
+	conn.cond = sync.NewCond(&conn.mu)
+	go conn.send()
+	return conn
+}
+
+// Output 发送远程输出，v2为原样的二进制消息，由浏览器解码
+// 旧协议为文本消息，跨两次输出的多字节字符合并后发送
+func (conn *TermConn) Output(p []byte) error {
+	return conn.enqueue(0, p)
+}
+
+// Notice 发送webssh自己的提示，v2为notice控制消息，浏览器可以与远程输出区分
+func (conn *TermConn) Notice(text string) error {
+	if conn.Protocol == ProtocolV2 {
+		return conn.Send(ControlMessage{Type: MessageNotice, Message: text})
+	}
+	return conn.enqueue(websocket.TextMessage, []byte(text))
+}
+
+// Send 发送控制消息，旧协议没有控制消息时忽略
+func (conn *TermConn) Send(msg ControlMessage) error {
+	if conn.Protocol != ProtocolV2 {
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.enqueue(websocket.TextMessage, data)
+}
+
+// Read 读取浏览器的下一条消息并解码为控制消息，只在连接出错时返回错误
+// 旧协议的文本及v2的二进制消息解码为input；v2中无效的控制消息回复error后跳过
+func (conn *TermConn) Read() (ControlMessage, error) {
+	for {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			return ControlMessage{}, err
+		}
+		msg, err := decodeMessage(conn.Protocol, messageType, p)
+		if err != nil {
+			conn.Send(ControlMessage{Type: MessageError, Message: err.Error()})
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// 按协议版本解码浏览器消息
+func decodeMessage(protocol, messageType int, p []byte) (ControlMessage, error) {
+	if protocol != ProtocolV2 {
+		if string(p) == "ping" {
+			return ControlMessage{Type: MessagePing}, nil
+		}
+		if match := legacyResize.FindStringSubmatch(string(p)); match != nil {
+			rows, _ := strconv.Atoi(match[1])
+			cols, _ := strconv.Atoi(match[2])
+			return ControlMessage{Type: MessageResize, Rows: rows, Cols: cols}, nil
+		}
+		return ControlMessage{Type: MessageInput, Data: string(p)}, nil
+	}
+	if messageType == websocket.BinaryMessage {
+		return ControlMessage{Type: MessageInput, Data: string(p)}, nil
+	}
+	var msg ControlMessage
+	if err := json.Unmarshal(p, &msg); err != nil {
+		return msg, fmt.Errorf("invalid control message: %v", err)
+	}
+	switch msg.Type {
+	case MessageInput, MessagePing:
+	case MessageResize:
+		if msg.Rows <= 0 || msg.Cols <= 0 || msg.Rows > 1000 || msg.Cols > 1000 {
+			return msg, fmt.Errorf("invalid terminal size %dx%d", msg.Rows, msg.Cols)
+		}
+	case MessageSignal:
+		if !terminalSignals[msg.Signal] {
+			return msg, fmt.Errorf("unsupported signal %q", msg.Signal)
+		}
+	default:
+		return msg, fmt.Errorf("unknown control message type %q", msg.Type)
+	}
+	return msg, nil
+}
@@ -0,0 +1,241 @@
+// Package core : 核心包
+package core
+
+import (
+	"encoding/json" //asciicast事件编码
+	"fmt"           //格式化
+	"log"           //日志库
+	"os"            //文件操作
+	"path"          //用户@主机通配符匹配
+	"path/filepath" //录像文件路径
+	"strings"       //字符串库
+	"sync"          //互斥锁
+	"time"          //时间日期库
+)
+
+// 录像配置
+var (
+	RecordDir       string                         //录像目录，为空时不录像
+	RecordMaxSize   int64    = 100 * 1024 * 1024   //单个录像文件最大字节数，超过后停止写入
+	RecordRetention          = 30 * 24 * time.Hour //录像保留时间，为0时不清理
+	recordPolicy    []string                       //需要录像的用户@主机通配符
+)
+
+// InitRecording 初始化终端录像
+// dir : 录像目录，为空时不录像
+// policy : 需要录像的用户@主机通配符，逗号分隔，如root@*,*@10.0.0.*
+func InitRecording(dir, policy string) error {
+	if dir == "" {
+		return nil
+	}
+	var patterns []string
+	for _, item := range strings.Split(policy, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if _, err := path.Match(item, ""); err != nil {
+			return fmt.Errorf("invalid record pattern %q: %v", item, err)
+		}
+		patterns = append(patterns, item)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	RecordDir, recordPolicy = dir, patterns
+	go cleanRecordings()
+	return nil
+}
+
+// 按录像策略判断是否录像，匹配目标主机的登录用户@主机
+func shouldRecord(sclient *SSHClient) bool {
+	if RecordDir == "" {
+		return false
+	}
+	target := sclient.Username + "@" + strings.Trim(sclient.IPAddress, "[]")
+	for _, pattern := range recordPolicy {
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// asciicast v2文件头
+type castHeader struct {
+	Version   int               `json:"version"`   //格式版本，固定为2
+	Width     int               `json:"width"`     //终端列数
+	Height    int               `json:"height"`    //终端行数
+	Timestamp int64             `json:"timestamp"` //开始时间
+	Title     string            `json:"title"`     //用户@主机
+	Env       map[string]string `json:"env"`       //终端环境变量
+}
+
+// RecordingInfo 录像元数据，与录像文件同名的.json文件中保存
+type RecordingInfo struct {
+	Name       string    `json:"name"`                 //录像名称，即不含扩展名的文件名
+	ID         string    `json:"id"`                   //终端ID
+	User       string    `json:"user"`                 //ssh登录用户
+	Host       string    `json:"host"`                 //目标主机
+	WebUser    string    `json:"webUser"`              //webssh用户
+	Start      time.Time `json:"start"`                //开始时间
+	Duration   float64   `json:"duration"`             //时长(s)
+	Bytes      int64     `json:"bytes"`                //终端输出字节数
+	Active     bool      `json:"active"`               //是否正在录像
+	Truncated  bool      `json:"truncated"`            //是否因超过大小限制而停止
+	Reason     string    `json:"reason"`               //结束原因: exit、timeout、lost、error、shutdown、closed
+	ExitStatus *int      `json:"exitStatus"`           //shell退出码，未正常退出时为空
+	ExitSignal string    `json:"exitSignal,omitempty"` //shell被信号终止时的信号名
+}
+
+// 终端录像，按asciicast v2格式记录输出及调整大小事件
+type recorder struct {
+	mu      sync.Mutex    //保护以下字段
+	file    *os.File      //录像文件
+	start   time.Time     //开始时间，事件时间为相对秒数
+	size    int64         //已写入字节数
+	stopped bool          //超过大小限制或写入失败后停止
+	partial []byte        //上一次输出末尾不完整的UTF-8字符
+	info    RecordingInfo //录像元数据
+}
+
+// 创建录像文件，文件名为开始时间及终端ID
+// id : 终端ID
+// sclient : SSH客户端，用于记录用户及主机
+// rows, cols : 初始终端大小
+func newRecorder(id string, sclient *SSHClient, rows, cols int) (*recorder, error) {
+	start := time.Now()
+	name := fmt.Sprintf("%s-%s", start.Format("20060102-150405"), id)
+	file, err := os.OpenFile(filepath.Join(RecordDir, name+".cast"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.Trim(sclient.IPAddress, "[]")
+	rec := &recorder{file: file, start: start, info: RecordingInfo{
+		Name:    name,
+		ID:      id,
+		User:    sclient.Username,
+		Host:    host,
+		WebUser: sclient.WebUser,
+		Start:   start,
+		Active:  true,
+	}}
+	rec.writeLine(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: start.Unix(),
+		Title:     sclient.Username + "@" + host,
+		Env:       map[string]string{"TERM": "xterm"},
+	})
+	rec.saveInfo()
+	return rec, nil
+}
+
+// 记录输出事件，跨两次输出的多字节字符合并后再记录
+func (rec *recorder) output(p []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	data := append(rec.partial, p...)
+	//末尾最多保留3个字节的不完整字符
+	cut := incompleteRune(data)
+	rec.partial = append([]byte(nil), data[cut:]...)
+	if !rec.stopped {
+		rec.info.Bytes += int64(len(p))
+	}
+	if cut > 0 {
+		rec.event("o", string(data[:cut]))
+	}
+}
+
+// 记录调整大小事件
+func (rec *recorder) resize(rows, cols int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// 写入事件，调用时必须持有锁
+func (rec *recorder) event(kind, data string) {
+	rec.writeLine([]interface{}{time.Since(rec.start).Seconds(), kind, data})
+}
+
+// 写入一行JSON，超过大小限制时写入标记事件后停止
+func (rec *recorder) writeLine(v interface{}) {
+	if rec.stopped {
+		return
+	}
+	line, _ := json.Marshal(v)
+	line = append(line, '\n')
+	if RecordMaxSize > 0 && rec.size+int64(len(line)) > RecordMaxSize {
+		rec.stopped, rec.info.Truncated = true, true
+		marker, _ := json.Marshal([]interface{}{time.Since(rec.start).Seconds(), "m", "recording size limit reached"})
+		rec.file.Write(append(marker, '\n'))
+		log.Printf("recording %s reached size limit", rec.file.Name())
+		return
+	}
+	n, err := rec.file.Write(line)
+	rec.size += int64(n)
+	if err != nil {
+		rec.stopped = true
+		log.Println(err)
+	}
+}
+
+// 记录结束原因及shell退出状态，只记录第一次
+// reason : 结束原因
+// err : session.Wait的返回值
+func (rec *recorder) end(reason string, err error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.info.Reason != "" {
+		return
+	}
+	rec.info.Reason = reason
+	if reason == "exit" {
+		rec.info.ExitStatus, rec.info.ExitSignal = exitStatus(err)
+	}
+}
+
+// 关闭录像文件并保存元数据
+func (rec *recorder) close() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.partial) > 0 {
+		rec.event("o", string(rec.partial))
+		rec.partial = nil
+	}
+	rec.stopped = true
+	rec.file.Close()
+	if rec.info.Reason == "" {
+		rec.info.Reason = "closed"
+	}
+	rec.info.Active = false
+	rec.info.Duration = time.Since(rec.start).Seconds()
+	rec.saveInfo()
+}
+
+// 保存元数据文件，调用时必须持有锁或尚未共享
+func (rec *recorder) saveInfo() {
+	data, _ := json.Marshal(rec.info)
+	if err := os.WriteFile(filepath.Join(RecordDir, rec.info.Name+".json"), data, 0600); err != nil {
+		log.Println(err)
+	}
+}
+
+// 定时删除超过保留时间的录像
+func cleanRecordings() {
+	for {
+		if RecordRetention > 0 {
+			files, _ := filepath.Glob(filepath.Join(RecordDir, "*.cast"))
+			for _, name := range files {
+				if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > RecordRetention {
+					if err := os.Remove(name); err != nil {
+						log.Println(err)
+					}
+					os.Remove(strings.TrimSuffix(name, ".cast") + ".json") //元数据
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
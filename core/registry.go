@@ -0,0 +1,247 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/rand"             //随机令牌
+	"encoding/base64"         //令牌编码
+	"errors"                  //错误处理
+	"golang.org/x/crypto/ssh" //ssh库
+	"sync"                    //互斥锁
+	"time"                    //时间日期库
+)
+
+// CodeSessionNotFound 会话令牌不存在、已过期或不属于当前web用户的错误码
+const CodeSessionNotFound = "SESSION_NOT_FOUND"
+
+// SessionTTL 会话空闲多久后过期，终端及文件操作使用期间不会过期
+var SessionTTL = 30 * time.Minute
+
+// SessionEntry 已认证的SSH会话
+type SessionEntry struct {
+	Token     string          //随机令牌
+	client    *SSHClient      //解析自sshInfo的连接信息
+	conn      *pooledConn     //连接池中的SSH连接，键盘交互认证时在打开终端前为空
+	mu        sync.Mutex      //保护conn、closed及addedKeys，串行化键盘交互认证的延迟连接
+	closed    bool            //是否已关闭
+	addedKeys []HostKeyResult //本次登录首次记录的主机密钥，第一次打开终端时提示
+	refs      int             //正在使用的终端及文件操作数量
+	expires   time.Time       //空闲过期时间
+}
+
+// SessionRegistry 服务端会话表，浏览器只持有令牌，不再在每个请求中携带密码
+type SessionRegistry struct {
+	mu       sync.Mutex               //保护sessions及会话的refs、expires
+	sessions map[string]*SessionEntry //令牌到会话的映射
+	once     sync.Once                //只启动一次过期清理
+}
+
+// Sessions 全局会话表
+var Sessions = &SessionRegistry{sessions: make(map[string]*SessionEntry)}
+
+// Create 认证并登记会话，返回会话令牌
+// 键盘交互认证需要在终端中回答问题，此时只登记连接信息，打开终端时再连接
+// sclient : 解析自sshInfo的SSH客户端，连接后写回主机密钥校验结果及协商算法
+func (r *SessionRegistry) Create(sclient *SSHClient) (*SessionEntry, error) {
+	r.once.Do(func() { go r.expireLoop() })
+	entry := &SessionEntry{client: sclient, expires: time.Now().Add(SessionTTL)}
+	if sclient.LoginType != LoginKeyboardInteractive {
+		conn, err := Pool.Get(sclient) //同一主机、用户及认证信息复用已有连接
+		if err != nil && ErrorCode(err) != CodeInteractiveRequired {
+			return nil, err
+		}
+		entry.conn = conn
+		entry.addedKeys = sclient.AddedHostKeys()
+	}
+	token, err := newToken()
+	if err != nil {
+		entry.close("")
+		return nil, err
+	}
+	entry.Token = token
+	r.mu.Lock()
+	r.sessions[token] = entry
+	r.mu.Unlock()
+	if entry.conn != nil {
+		r.watch(entry, entry.conn)
+	}
+	return entry, nil
+}
+
+// Pending 会话是否还在等待键盘交互认证
+func (entry *SessionEntry) Pending() bool {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.conn == nil
+}
+
+// Client 返回会话的连接信息，用于读取主机密钥校验结果及协商算法
+func (entry *SessionEntry) Client() *SSHClient {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.conn != nil {
+		return entry.conn.snapshot()
+	}
+	return entry.client
+}
+
+// Acquire 取会话的SSH客户端副本，副本与连接池共享SSH连接及sftp客户端，Close时不会关闭连接
+// 使用结束后必须调用返回的release
+// token : 会话令牌
+// webUser : 当前web用户，必须与创建会话的用户一致
+// challenge : 键盘交互认证问题转发，为空时未连接的会话返回INTERACTIVE_REQUIRED
+func (r *SessionRegistry) Acquire(token, webUser string, challenge ssh.KeyboardInteractiveChallenge) (*SSHClient, func(), error) {
+	r.mu.Lock()
+	entry, ok := r.sessions[token]
+	if !ok || entry.client.WebUser != webUser {
+		r.mu.Unlock()
+		return nil, nil, &CodeError{Code: CodeSessionNotFound, Err: errors.New("session not found or expired")}
+	}
+	entry.refs++
+	r.mu.Unlock()
+	release := func() {
+		r.mu.Lock()
+		entry.refs--
+		entry.expires = time.Now().Add(SessionTTL)
+		r.mu.Unlock()
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.closed {
+		release()
+		return nil, nil, &CodeError{Code: CodeSessionNotFound, Err: errors.New("session not found or expired")}
+	}
+	//键盘交互认证的会话在第一次打开终端时连接
+	if entry.conn == nil {
+		if challenge == nil {
+			release()
+			return nil, nil, &CodeError{Code: CodeInteractiveRequired,
+				Err: errors.New("session is waiting for keyboard-interactive authentication, open a terminal first")}
+		}
+		entry.client.Challenge = challenge
+		conn, err := Pool.Get(entry.client)
+		entry.client.Challenge = nil
+		if err != nil {
+			audit := NewAuditEvent(AuditLoginFailed, entry.client)
+			audit.Session, audit.Error = SessionID(token), err.Error()
+			Audit(audit)
+			release()
+			return nil, nil, err
+		}
+		entry.conn = conn
+		entry.addedKeys = entry.client.AddedHostKeys()
+		audit := NewAuditEvent(AuditLogin, entry.client)
+		audit.Session = SessionID(token)
+		Audit(audit)
+		r.watch(entry, conn)
+	}
+	//终端及文件操作期间保持连接池引用，会话关闭后连接也不会被提前关闭
+	conn := entry.conn
+	shared, err := conn.shared()
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	//连接池不区分字符编码，复用的连接按本会话登录时选择的编码转换
+	shared.Encoding = entry.client.Encoding
+	Pool.Ref(conn)
+	return shared, func() {
+		Pool.Put(conn)
+		release()
+	}, nil
+}
+
+// TakeAddedHostKeys 返回会话首次记录的主机密钥并清空，终端只提示一次
+// token : 会话令牌
+func (r *SessionRegistry) TakeAddedHostKeys(token string) []HostKeyResult {
+	r.mu.Lock()
+	entry, ok := r.sessions[token]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	added := entry.addedKeys
+	entry.addedKeys = nil
+	return added
+}
+
+// Remove 删除会话，关闭会话的隧道并释放连接池引用
+// token : 会话令牌
+// webUser : 当前web用户
+func (r *SessionRegistry) Remove(token, webUser string) error {
+	r.mu.Lock()
+	entry, ok := r.sessions[token]
+	if !ok || entry.client.WebUser != webUser {
+		r.mu.Unlock()
+		return &CodeError{Code: CodeSessionNotFound, Err: errors.New("session not found or expired")}
+	}
+	delete(r.sessions, token)
+	r.mu.Unlock()
+	entry.close("closed")
+	Tunnels.CloseSession(token)
+	return nil
+}
+
+// 释放会话持有的连接池引用，连接没有其他使用者时进入空闲状态
+// reason : 写入审计日志的关闭原因，为空时不记录
+func (entry *SessionEntry) close(reason string) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.closed {
+		return
+	}
+	entry.closed = true
+	if reason != "" {
+		audit := NewAuditEvent(AuditSessionEnd, entry.client)
+		audit.Session, audit.Reason = SessionID(entry.Token), reason
+		Audit(audit)
+	}
+	if entry.conn != nil {
+		Pool.Put(entry.conn)
+	}
+}
+
+// SSH连接断开时删除会话
+func (r *SessionRegistry) watch(entry *SessionEntry, conn *pooledConn) {
+	go func() {
+		<-conn.done
+		r.mu.Lock()
+		if r.sessions[entry.Token] == entry {
+			delete(r.sessions, entry.Token)
+		}
+		r.mu.Unlock()
+		entry.close("disconnected")
+		Tunnels.CloseSession(entry.Token)
+	}()
+}
+
+// 定时清理空闲过期的会话
+func (r *SessionRegistry) expireLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var expired []*SessionEntry
+		r.mu.Lock()
+		for token, entry := range r.sessions {
+			if entry.refs == 0 && now.After(entry.expires) {
+				delete(r.sessions, token)
+				expired = append(expired, entry)
+			}
+		}
+		r.mu.Unlock()
+		for _, entry := range expired {
+			entry.close("expired")
+			Tunnels.CloseSession(entry.Token)
+		}
+	}
+}
+
+// 生成随机会话令牌
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
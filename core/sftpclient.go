@@ -8,11 +8,22 @@ import (
 	"os"                  //操作系统信息
 )
 
-// CreateSftp 创建sftp客户端
+// CreateSftp 创建sftp客户端，未连接时先生成SSH客户端
+// 与连接池共享连接时复用连接池中的sftp客户端
 func (sclient *SSHClient) CreateSftp() error {
-	err := sclient.GenerateClient() //生成SSH客户端
-	if err != nil {
-		return err
+	if sclient.pool != nil {
+		client, err := sclient.pool.sftpClient()
+		if err != nil {
+			return err
+		}
+		sclient.Sftp = client
+		return nil
+	}
+	if sclient.Client == nil {
+		err := sclient.GenerateClient() //生成SSH客户端
+		if err != nil {
+			return err
+		}
 	}
 	client, err := sftp.NewClient(sclient.Client) //创建SFTP客户端
 	if err != nil {
@@ -23,8 +34,12 @@ func (sclient *SSHClient) CreateSftp() error {
 }
 
 // Mkdirs 创建文件夹
-// path 文件夹路径名
+// path 文件夹路径名，UTF-8，按远程主机的编码转换
 func (sclient *SSHClient) Mkdirs(path string) error {
+	path, err := sclient.RemotePath(path)
+	if err != nil {
+		return err
+	}
 	//如果路径不存在
 	if _, err := sclient.Sftp.Stat(path); os.IsNotExist(err) {
 		return sclient.Sftp.MkdirAll(path) //根据路径创建文件夹
@@ -33,16 +48,52 @@ func (sclient *SSHClient) Mkdirs(path string) error {
 }
 
 // Download 下载文件
-// srcPath 文件路径
+// srcPath 文件路径，UTF-8，按远程主机的编码转换
 func (sclient *SSHClient) Download(srcPath string) (*sftp.File, error) {
+	srcPath, err := sclient.RemotePath(srcPath)
+	if err != nil {
+		return nil, err
+	}
 	return sclient.Sftp.Open(srcPath) //打开文件，返回sftp.File结构的指针与错误接口
 }
 
+// ReadDir 读取文件夹，返回的文件名已转换为UTF-8
+// path 文件夹路径，UTF-8，按远程主机的编码转换
+func (sclient *SSHClient) ReadDir(path string) ([]os.FileInfo, error) {
+	path, err := sclient.RemotePath(path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := sclient.Sftp.ReadDir(path)
+	if err != nil || sclient.charset() == nil {
+		return files, err
+	}
+	for i, file := range files {
+		files[i] = localFileInfo{FileInfo: file, name: sclient.LocalName(file.Name())}
+	}
+	return files, nil
+}
+
+// 文件名已转换为UTF-8的文件信息
+type localFileInfo struct {
+	os.FileInfo
+	name string //UTF-8文件名
+}
+
+// Name 返回UTF-8文件名
+func (info localFileInfo) Name() string {
+	return info.name
+}
+
 // Upload 上传文件
 // file : mime文件对象
 // id: 上传标识
-// dstPath : 目标路径
+// dstPath : 目标路径，UTF-8，按远程主机的编码转换
 func (sclient *SSHClient) Upload(file multipart.File, id, dstPath string) error {
+	dstPath, err := sclient.RemotePath(dstPath)
+	if err != nil {
+		return err
+	}
 	dstFile, err := sclient.Sftp.Create(dstPath) //创建目标路径
 	if err != nil {
 		return err
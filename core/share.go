@@ -0,0 +1,177 @@
+// Package core : 核心包
+package core
+
+import (
+	"errors"                       //错误处理
+	"fmt"                          //格式化
+	"github.com/gorilla/websocket" //websocket库
+	"strings"                      //字符串库
+	"time"                         //时间日期库
+	"unicode"                      //过滤控制字符
+)
+
+// CodeTerminalNotFound 终端不存在、已关闭或邀请已失效的错误码
+const CodeTerminalNotFound = "TERMINAL_NOT_FOUND"
+
+// 终端连接的角色
+const (
+	RoleOwner     = "owner" //会话所有者，可以邀请及转移控制权，正常关闭时结束终端
+	RoleReadWrite = "rw"    //获得控制权后可以输入
+	RoleReadOnly  = "ro"    //只能观看
+)
+
+// Subscriber 连接到终端的websocket
+type Subscriber struct {
+	ID     string    //连接ID
+	Name   string    //显示给其他连接的名字
+	Role   string    //角色
+	Joined time.Time //连接时间
+	invite string    //观看者使用的邀请令牌
+	ws     *TermConn //websocket连接
+}
+
+// SubscriberInfo 终端连接状态
+type SubscriberInfo struct {
+	ID      string    `json:"id"`      //连接ID
+	Name    string    `json:"name"`    //名字
+	Role    string    `json:"role"`    //角色
+	Joined  time.Time `json:"joined"`  //连接时间
+	Control bool      `json:"control"` //是否持有键盘控制权
+}
+
+// 创建终端连接，名字会显示在全部连接的终端中，去掉控制字符并限制长度
+func newSubscriber(ws *TermConn, name, role string) *Subscriber {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 32 {
+		name = string(runes[:32])
+	}
+	return &Subscriber{ID: newTerminalID(), Name: name, Role: role, Joined: time.Now(), ws: ws}
+}
+
+// Invite 生成邀请令牌，持有令牌的浏览器可以以指定角色连接终端，终端关闭后失效
+// role : rw或ro
+func (term *Terminal) Invite(role string) (string, error) {
+	if role != RoleReadWrite && role != RoleReadOnly {
+		return "", fmt.Errorf("invalid role %q, want rw or ro", role)
+	}
+	invite, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	if term.closed {
+		return "", &CodeError{Code: CodeTerminalNotFound, Err: errors.New("terminal closed")}
+	}
+	term.invites[invite] = role
+	return invite, nil
+}
+
+// Revoke 撤销邀请，并断开通过该邀请连接的观看者
+func (term *Terminal) Revoke(invite string) {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	delete(term.invites, invite)
+	for _, sub := range append([]*Subscriber(nil), term.subs...) {
+		if sub.invite == invite {
+			sub.ws.CloseWith(websocket.ClosePolicyViolation, "invite revoked")
+			term.detachLocked(sub)
+		}
+	}
+}
+
+// ByInvite 按邀请令牌取终端
+func (r *TerminalRegistry) ByInvite(invite string) (*Terminal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, term := range r.terms {
+		term.mu.Lock()
+		_, ok := term.invites[invite]
+		term.mu.Unlock()
+		if ok {
+			return term, nil
+		}
+	}
+	return nil, &CodeError{Code: CodeTerminalNotFound, Err: errors.New("invite not found or terminal closed")}
+}
+
+// Join 以邀请的角色连接websocket并回放offset之后的输出
+// ws : websocket连接
+// name : 显示给其他连接的名字
+// invite : 邀请令牌
+// offset : 浏览器已收到的输出字节数
+func (term *Terminal) Join(ws *TermConn, name, invite string, offset int64) (*Subscriber, error) {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	role, ok := term.invites[invite]
+	if term.closed || !ok {
+		return nil, &CodeError{Code: CodeTerminalNotFound, Err: errors.New("invite not found or terminal closed")}
+	}
+	sub := newSubscriber(ws, name, role)
+	sub.invite = invite
+	if err := term.attachLocked(sub, offset); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Users 列出终端的全部连接
+func (term *Terminal) Users() []SubscriberInfo {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	users := make([]SubscriberInfo, 0, len(term.subs))
+	for _, sub := range term.subs {
+		users = append(users, SubscriberInfo{
+			ID:      sub.ID,
+			Name:    sub.Name,
+			Role:    sub.Role,
+			Joined:  sub.Joined,
+			Control: term.hasControlLocked(sub),
+		})
+	}
+	return users
+}
+
+// SetControl 把键盘控制权转移给指定连接，只能转移给所有者或可写的观看者
+// id : 连接ID
+func (term *Terminal) SetControl(id string) error {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	for _, sub := range term.subs {
+		if sub.ID != id {
+			continue
+		}
+		switch sub.Role {
+		case RoleOwner:
+			term.control = nil //交还所有者
+		case RoleReadWrite:
+			term.control = sub
+		default:
+			return fmt.Errorf("%s is read-only", sub.Name)
+		}
+		term.noticeLocked("%s has keyboard control", sub.Name)
+		return nil
+	}
+	return &CodeError{Code: CodeTerminalNotFound, Err: errors.New("user not attached")}
+}
+
+// 是否持有键盘控制权，调用时必须持有锁
+func (term *Terminal) hasControlLocked(sub *Subscriber) bool {
+	if term.control != nil {
+		return term.control == sub
+	}
+	return sub.Role == RoleOwner
+}
+
+// 在全部连接的终端中显示提示，提示不写入回放缓冲区，调用时必须持有锁
+func (term *Terminal) noticeLocked(format string, args ...interface{}) {
+	message := fmt.Sprintf("\r\n\u001B[33m[webssh] "+format+"\u001B[0m\r\n", args...)
+	for _, sub := range term.subs {
+		sub.ws.Notice(message)
+	}
+}
@@ -0,0 +1,228 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/subtle"           //认证比较
+	"encoding/binary"         //端口解码
+	"errors"                  //错误处理
+	"fmt"                     //格式化
+	"golang.org/x/crypto/ssh" //ssh库
+	"io"                      //io操作
+	"net"                     //网络库
+	"strconv"                 //端口格式化
+	"time"                    //时间日期库
+)
+
+// SOCKS5握手超时
+const socksHandshakeTimeout = 10 * time.Second
+
+// SOCKS5应答码
+const (
+	socksSucceeded          = 0x00 //成功
+	socksGeneralFailure     = 0x01 //一般错误
+	socksNotAllowed         = 0x02 //规则不允许
+	socksConnectionRefused  = 0x05 //连接被拒绝
+	socksCommandUnsupported = 0x07 //不支持的命令
+	socksAddressUnsupported = 0x08 //不支持的地址类型
+)
+
+// NewSocksTunnel 在webssh服务器上监听SOCKS5代理，客户端请求的地址经会话的SSH连接连接(ssh -D)
+// 只允许-socks中的web用户使用；监听地址不是回环地址时必须开启用户名密码认证
+// port : 监听端口，为0时随机分配
+// allow : 允许连接的来源IP或CIDR，为空时只允许创建代理的浏览器IP
+// auth : 是否生成随机用户名及密码，代理客户端必须使用它们认证
+func NewSocksTunnel(sclient *SSHClient, release func(), token string, port int, allow []string, auth bool) (*Tunnel, error) {
+	if !socksAllowed(sclient.WebUser) {
+		return nil, errors.New("SOCKS5 proxy is not allowed for this user")
+	}
+	if ip := net.ParseIP(TunnelListenHost); TunnelListenHost != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		auth = true //监听在其他地址时必须认证
+	}
+	listener, nets, err := listenLocal(port, allow, sclient.ClientIP)
+	if err != nil {
+		return nil, err
+	}
+	tunnel := newTunnel(TunnelSocks, sclient, release, token, "")
+	tunnel.Listen, tunnel.listener, tunnel.allow = listener.Addr().String(), listener, nets
+	if auth {
+		tunnel.username = "webssh"
+		if tunnel.password, err = newToken(); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	if err := Tunnels.register(tunnel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go tunnel.accept(tunnel.socksDial)
+	return tunnel, nil
+}
+
+// Password 返回SOCKS5认证密码，只在创建代理时返回给浏览器
+func (tunnel *Tunnel) Password() string {
+	return tunnel.password
+}
+
+// web用户是否可以使用SOCKS5代理
+func socksAllowed(webUser string) bool {
+	for _, user := range tunnelSocksUsers {
+		if user == "*" || user == webUser {
+			return true
+		}
+	}
+	return false
+}
+
+// 完成SOCKS5握手后经SSH连接连接客户端请求的地址，并向客户端应答结果
+func (tunnel *Tunnel) socksDial(local net.Conn) (net.Conn, error) {
+	local.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	defer local.SetDeadline(time.Time{})
+	target, err := socksHandshake(local, tunnel.username, tunnel.password)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := tunnel.client.Dial("tcp", target)
+	if err != nil {
+		socksReply(local, socksErrorCode(err))
+		return nil, fmt.Errorf("socks connect %s: %v", target, err)
+	}
+	if err := socksReply(local, socksSucceeded); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
+
+// SOCKS5握手，协商认证方式并读取CONNECT请求，返回目标地址
+// username, password : 认证用户名及密码，用户名为空时不认证
+func socksHandshake(conn net.Conn, username, password string) (string, error) {
+	//版本及客户端支持的认证方式
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != 5 {
+		return "", fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(0x00) //不认证
+	if username != "" {
+		method = 0x02 //用户名密码认证
+	}
+	offered := false
+	for _, item := range methods {
+		offered = offered || item == method
+	}
+	if !offered {
+		conn.Write([]byte{5, 0xff})
+		return "", errors.New("socks client does not support the required authentication method")
+	}
+	if _, err := conn.Write([]byte{5, method}); err != nil {
+		return "", err
+	}
+	if method == 0x02 {
+		if err := socksAuth(conn, username, password); err != nil {
+			return "", err
+		}
+	}
+	//请求: 版本、命令、保留字节及地址类型
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != 5 {
+		socksReply(conn, socksGeneralFailure)
+		return "", fmt.Errorf("unsupported socks version %d in request", request[0])
+	}
+	if request[1] != 0x01 {
+		socksReply(conn, socksCommandUnsupported)
+		return "", fmt.Errorf("unsupported socks command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case 0x01: //IPv4
+		addr := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case 0x03: //域名，由SSH服务器解析
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		addr := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = string(addr)
+	case 0x04: //IPv6
+		addr := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	default:
+		socksReply(conn, socksAddressUnsupported)
+		return "", fmt.Errorf("unsupported socks address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// 用户名密码认证(RFC 1929)
+func socksAuth(conn net.Conn, username, password string) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != 1 {
+		conn.Write([]byte{1, 1})
+		return fmt.Errorf("unsupported socks authentication version %d", header[0])
+	}
+	user := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return err
+	}
+	pass := make([]byte, length[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(user, []byte(username)) != 1 || subtle.ConstantTimeCompare(pass, []byte(password)) != 1 {
+		conn.Write([]byte{1, 1})
+		return errors.New("socks authentication failed")
+	}
+	_, err := conn.Write([]byte{1, 0})
+	return err
+}
+
+// 向客户端应答，绑定地址固定为0.0.0.0:0
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{5, code, 0, 0x01, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// 把SSH通道打开失败的原因转换为SOCKS5应答码
+func socksErrorCode(err error) byte {
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) {
+		switch channelErr.Reason {
+		case ssh.Prohibited:
+			return socksNotAllowed
+		case ssh.ConnectionFailed:
+			return socksConnectionRefused
+		}
+	}
+	return socksGeneralFailure
+}
@@ -0,0 +1,88 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"   //字节操作
+	"net"     //内存连接
+	"testing" //测试
+)
+
+// 按预设输入应答的连接，输入读完后返回EOF
+type socksTestConn struct {
+	net.Conn               //未用到的方法
+	input    *bytes.Reader //客户端发送的数据
+	output   bytes.Buffer  //发给客户端的应答
+}
+
+func (c *socksTestConn) Read(b []byte) (int, error)  { return c.input.Read(b) }
+func (c *socksTestConn) Write(b []byte) (int, error) { return c.output.Write(b) }
+
+// 执行SOCKS5握手，返回目标地址及发给客户端的全部应答
+func runSocksHandshake(input []byte, username, password string) (string, []byte, error) {
+	conn := &socksTestConn{input: bytes.NewReader(input)}
+	target, err := socksHandshake(conn, username, password)
+	return target, conn.output.Bytes(), err
+}
+
+// 拼接字节及字符串
+func socksBytes(parts ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		switch v := part.(type) {
+		case string:
+			buf.WriteString(v)
+		case []byte:
+			buf.Write(v)
+		case int:
+			buf.WriteByte(byte(v))
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestSocksHandshake(t *testing.T) {
+	noAuth := socksBytes(5, 1, 0)
+	userAuth := socksBytes(5, 2, 0, 2)
+	okReply := socksBytes(5, 0)
+	authReply := socksBytes(5, 2)
+	connectIPv4 := socksBytes(5, 1, 0, 1, 127, 0, 0, 1, 0, 80)
+	failure := func(code int) []byte { return socksBytes(5, code, 0, 1, 0, 0, 0, 0, 0, 0) }
+	tests := []struct {
+		name     string
+		username string
+		password string
+		input    []byte
+		target   string //为空时握手应失败
+		reply    []byte
+	}{
+		{"no auth ipv4", "", "", socksBytes(noAuth, connectIPv4), "127.0.0.1:80", okReply},
+		{"domain", "", "", socksBytes(noAuth, 5, 1, 0, 3, 11, "example.com", 1, 187), "example.com:443", okReply},
+		{"ipv6", "", "", socksBytes(noAuth, 5, 1, 0, 4, []byte(net.IPv6loopback), 0, 22), "[::1]:22", okReply},
+		{"auth ok", "user", "pass", socksBytes(userAuth, 1, 4, "user", 4, "pass", connectIPv4), "127.0.0.1:80", socksBytes(authReply, 1, 0)},
+		{"auth wrong password", "user", "pass", socksBytes(userAuth, 1, 4, "user", 5, "wrong", connectIPv4), "", socksBytes(authReply, 1, 1)},
+		{"auth wrong user", "user", "pass", socksBytes(userAuth, 1, 3, "bob", 4, "pass", connectIPv4), "", socksBytes(authReply, 1, 1)},
+		{"auth bad subnegotiation version", "user", "pass", socksBytes(userAuth, 5, 4, "user", 4, "pass", connectIPv4), "", socksBytes(authReply, 1, 1)},
+		{"auth required but not offered", "user", "pass", socksBytes(noAuth, connectIPv4), "", socksBytes(5, 0xff)},
+		{"no auth not offered", "", "", socksBytes(5, 1, 2), "", socksBytes(5, 0xff)},
+		{"bad greeting version", "", "", socksBytes(4, 1, 0), "", nil},
+		{"bad request version", "", "", socksBytes(noAuth, 4, 1, 0, 1, 127, 0, 0, 1, 0, 80), "", socksBytes(okReply, failure(socksGeneralFailure))},
+		{"bind unsupported", "", "", socksBytes(noAuth, 5, 2, 0, 1, 127, 0, 0, 1, 0, 80), "", socksBytes(okReply, failure(socksCommandUnsupported))},
+		{"udp associate unsupported", "", "", socksBytes(noAuth, 5, 3, 0, 1, 127, 0, 0, 1, 0, 80), "", socksBytes(okReply, failure(socksCommandUnsupported))},
+		{"address type unsupported", "", "", socksBytes(noAuth, 5, 1, 0, 9, 0, 80), "", socksBytes(okReply, failure(socksAddressUnsupported))},
+		{"truncated request", "", "", socksBytes(noAuth, 5, 1, 0, 1, 127, 0), "", okReply},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, reply, err := runSocksHandshake(tt.input, tt.username, tt.password)
+			if tt.target == "" && err == nil {
+				t.Fatalf("handshake succeeded with target %s", target)
+			}
+			if tt.target != "" && (err != nil || target != tt.target) {
+				t.Fatalf("got %q, %v; want %s", target, err, tt.target)
+			}
+			if !bytes.Equal(reply, tt.reply) {
+				t.Fatalf("reply %v, want %v", reply, tt.reply)
+			}
+		})
+	}
+}
@@ -2,18 +2,22 @@
 package core
 
 import (
-	"encoding/base64"              //base64编码
-	"encoding/json"                //json编码
-	"fmt"                          //格式化输入输出
-	"github.com/gorilla/websocket" //websocket库
-	"golang.org/x/crypto/ssh"      //ssh库
-	"log"                          //日志库
-	"net"                          //网络库
-	"strconv"                      //字符串转换库
-	"strings"                      //字符串库
-	"time"                         //日期时间库
+	"context"                 //跳板机连接超时
+	"encoding/base64"         //base64编码
+	"encoding/json"           //json编码
+	"errors"                  //错误处理
+	"fmt"                     //格式化输入输出
+	"golang.org/x/crypto/ssh" //ssh库
+	"net"                     //网络库
+	"strings"                 //字符串库
+	"sync/atomic"             //握手超时标记
+	"time"                    //日期时间库
 )
 
+// HandshakeTimeout SSH握手及认证的超时，不含等待浏览器回答键盘交互问题的时间
+// 服务端或跳板机不响应时不会一直占用连接池及批量执行的槽位
+var HandshakeTimeout = 30 * time.Second
+
 // DecodedMsgToSSHClient 解码字符串为SSH客户端信息
 // 返回SSH客户端与错误码
 func DecodedMsgToSSHClient(sshInfo string) (SSHClient, error) {
@@ -26,40 +30,92 @@ func DecodedMsgToSSHClient(sshInfo string) (SSHClient, error) {
 	if err != nil {
 		return client, err
 	}
-	//如果IPAddress字段含有:与首字符不是[
-	if strings.Contains(client.IPAddress, ":") && string(client.IPAddress[0]) != "[" {
-		client.IPAddress = "[" + client.IPAddress + "]" //为字符串前后添加[]
+	client.IPAddress = normalizeIPAddress(client.IPAddress)
+	//校验字符编码
+	if _, err := lookupEncoding(client.Encoding); err != nil {
+		return client, err
+	}
+	//跳板机未填写的字段使用默认值
+	for i := range client.JumpHosts {
+		hop := &client.JumpHosts[i]
+		hop.IPAddress = normalizeIPAddress(hop.IPAddress)
+		if hop.Username == "" {
+			hop.Username = "root"
+		}
+		if hop.Port == 0 {
+			hop.Port = 22
+		}
+		hop.JumpHosts = nil //跳板机不支持再嵌套跳板机
 	}
 	return client, nil //返回SSH客户端实例与错误码
 }
 
+// 如果IP地址含有:且首字符不是[，为字符串前后添加[]
+func normalizeIPAddress(ipAddress string) string {
+	if strings.Contains(ipAddress, ":") && string(ipAddress[0]) != "[" {
+		return "[" + ipAddress + "]"
+	}
+	return ipAddress
+}
+
 // GenerateClient 创建ssh客户端
+// 配置了跳板机时依次经过每一台跳板机连接到下一跳，最后连接目标主机
 func (sclient *SSHClient) GenerateClient() error {
+	var via *ssh.Client //上一跳的SSH客户端，直连时为空
+	for i := range sclient.JumpHosts {
+		hop := &sclient.JumpHosts[i]
+		hop.Challenge = sclient.Challenge //跳板机的键盘交互认证同样转发给浏览器
+		hop.Proxy = sclient.Proxy         //第一跳经过同一代理连接
+		hop.WebUser = sclient.WebUser     //跳板机同样可以使用内置CA签发的证书
+		client, err := hop.dial(via)
+		if err != nil {
+			sclient.Close() //关闭已建立的跳板机连接
+			return fmt.Errorf("jump host %s:%d: %w", hop.IPAddress, hop.Port, err)
+		}
+		sclient.jumpClients = append(sclient.jumpClients, client)
+		via = client
+	}
+	client, err := sclient.dial(via)
+	if err != nil {
+		sclient.Close()
+		return err
+	}
+	sclient.Client = client //存储连接成功的SSH客户端实例到SSHClient结构的Client字段
+	return nil
+}
+
+// dial 连接到本主机
+// via : 上一跳的SSH客户端，为空时直接通过tcp连接
+func (sclient *SSHClient) dial(via *ssh.Client) (*ssh.Client, error) {
 	//局部变量声明
 	var (
 		auth         []ssh.AuthMethod  //SSH授权方法
 		addr         string            //地址
 		clientConfig *ssh.ClientConfig //SSH客户端配置
-		client       *ssh.Client       //SSH客户端
 		config       ssh.Config        //SSH配置
 		err          error             //错误
 	)
-	auth = make([]ssh.AuthMethod, 0) //分配授权方法内存
-	//使用密码登陆
-	if sclient.LoginType == 0 {
-		auth = append(auth, ssh.Password(sclient.Password)) //添加SSH密码
-	} else { //使用密钥登陆
-		//取私有密钥签名
-		if signer, err := ssh.ParsePrivateKey([]byte(sclient.Password)); err != nil {
-			return err
-		} else {
-			auth = append(auth, ssh.PublicKeys(signer)) //使用SSH公钥
-		}
+	//按登录类型生成认证方法
+	if auth, err = sclient.authMethods(); err != nil {
+		return nil, err
+	}
+	//按sshInfo、主机及默认配置选择算法
+	profileName, profile, err := sclient.algorithmProfile()
+	if err != nil {
+		return nil, err
 	}
 	//SSH配置
 	config = ssh.Config{
-		//SSH加密类型
-		Ciphers: []string{"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-gcm@openssh.com", "arcfour256", "arcfour128", "aes128-cbc", "3des-cbc", "aes192-cbc", "aes256-cbc"},
+		KeyExchanges: profile.KeyExchanges, //密钥交换算法
+		Ciphers:      profile.Ciphers,      //SSH加密类型
+		MACs:         profile.MACs,         //消息认证算法
+	}
+	//格式化地址为 IP地址:端口 形式
+	addr = fmt.Sprintf("%s:%d", sclient.IPAddress, sclient.Port)
+	//优先协商known_hosts中已记录的密钥类型
+	hostKeyAlgorithms, err := profile.hostKeyAlgorithms(profileName, HostKeys.Algorithms(addr))
+	if err != nil {
+		return nil, err
 	}
 	//SSH客户端配置
 	clientConfig = &ssh.ClientConfig{
@@ -67,116 +123,69 @@ func (sclient *SSHClient) GenerateClient() error {
 		Auth:    auth,             //授权
 		Timeout: 5 * time.Second,  //超时
 		Config:  config,           //配置
-		//回调
-		HostKeyCallback: func(hostname string, //主机名
-			remote net.Addr, //地址
-			key ssh.PublicKey) error {
-			return nil
-		},
+		//主机密钥校验，按known_hosts及校验策略处理
+		HostKeyCallback:   HostKeys.HostKeyCallback(&sclient.HostKey),
+		HostKeyAlgorithms: hostKeyAlgorithms,
 	}
-	//格式化地址为 IP地址:端口 形式
-	addr = fmt.Sprintf("%s:%d", sclient.IPAddress, sclient.Port)
-	//连接到SSH服务器
-	//连接类型为tcp
-	if client, err = ssh.Dial("tcp", addr, clientConfig); err != nil {
-		return err
+	var conn net.Conn
+	if via == nil {
+		//直连SSH服务器，连接类型为tcp，按配置经过代理
+		conn, err = dialTCP(sclient.Proxy, addr, clientConfig.Timeout)
+	} else {
+		//通过上一跳打开direct-tcpip通道连接
+		ctx, cancel := context.WithTimeout(context.Background(), clientConfig.Timeout)
+		conn, err = via.DialContext(ctx, "tcp", addr)
+		cancel()
 	}
-	sclient.Client = client //存储连接成功的SSH客户端实例到SSHClient结构的Client字段
-	return nil
-}
-
-// InitTerminal 初始化终端
-// ws : WebSocket连接对象
-// rows : 行数
-// cols : 列数
-func (sclient *SSHClient) InitTerminal(ws *websocket.Conn, rows, cols int) *SSHClient {
-	sshSession, err := sclient.Client.NewSession() //创建SSH会话
 	if err != nil {
-		log.Println(err)
-		return nil
-	}
-	sclient.Session = sshSession                  //保存SSH会话
-	sclient.StdinPipe, _ = sshSession.StdinPipe() //保存标准输入管道
-	wsOutput := new(wsOutput)                     //实例化Websocket对象
-	sshSession.Stdout = wsOutput                  //SSH会话标准输出流
-	sshSession.Stderr = wsOutput                  //SSH会话标准错误流
-	wsOutput.ws = ws                              //保存Websocket实例
-	//终端模式
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
-	}
-	//请求pty与远程主机上的会话的关联
-	if err := sshSession.RequestPty("xterm", rows, cols, modes); err != nil {
-		return nil
-	}
-	//在远程主机上启动一个登录Shell
-	if err := sshSession.Shell(); err != nil {
-		return nil
-	}
-	return sclient //返回SSH客户端实例
-}
-
-// Connect 连接WebSocket服务端
-func (sclient *SSHClient) Connect(ws *websocket.Conn, timeout time.Duration, closeTip string) {
-	stopCh := make(chan struct{}) //创建一个传入结构的信道
-	//协程处理用户输入
-	go func() {
-		for {
-			// p为用户输入
-			_, p, err := ws.ReadMessage() //读取WebSocket消息
-			if err != nil {
-				close(stopCh) //读取失败，关闭信道
-				return
-			}
-			//忽略ping
-			if string(p) == "ping" {
-				continue
-			}
-			//resize消息
-			if strings.Contains(string(p), "resize") {
-				resizeSlice := strings.Split(string(p), ":")    //分割消息
-				rows, _ := strconv.Atoi(resizeSlice[1])         //行
-				cols, _ := strconv.Atoi(resizeSlice[2])         //列
-				err := sclient.Session.WindowChange(rows, cols) //重置窗口大小
-				if err != nil {
-					log.Println(err)
-					close(stopCh) //重置窗口大小失败，关闭信道
-					return
-				}
-				continue //继续处理下一消息
-			}
-			//普通消息直接写入输出流
-			_, err = sclient.StdinPipe.Write(p)
-			if err != nil {
-				close(stopCh) //写入失败，关闭信道
-				return
-			}
+		return nil, err
+	}
+	//握手及认证超时后关闭连接，跳板机的通道不支持SetDeadline，转发键盘交互问题期间由ChallengeTimeout限制
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(HandshakeTimeout, func() {
+		timedOut.Store(true)
+		conn.Close()
+	})
+	defer timer.Stop()
+	if challenge := sclient.Challenge; challenge != nil {
+		sclient.Challenge = func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			timer.Stop()
+			defer timer.Reset(HandshakeTimeout)
+			return challenge(name, instruction, questions, echos)
 		}
-	}()
-
-	//延迟执行函数
-	defer func() {
-		ws.Close()      //关闭Websocket连接(ws表示SSH服务端的Websocket)
-		sclient.Close() //关闭SSH客户端
-		//recover捕获到panic的错误信息，并允许程序恢复正常的执行流程
-		if err := recover(); err != nil {
-			log.Println(err)
+		defer func() { sclient.Challenge = challenge }()
+	}
+	kex := &kexConn{Conn: conn} //记录双方的算法列表
+	sshConn, chans, reqs, err := ssh.NewClientConn(kex, addr, clientConfig)
+	if err == nil && !timer.Stop() {
+		sshConn.Close() //认证刚完成时超时，连接已被关闭
+		err = errors.New("connection closed")
+	}
+	if err != nil {
+		conn.Close()
+		if timedOut.Load() {
+			return nil, fmt.Errorf("ssh handshake with %s timed out after %v", addr, HandshakeTimeout)
+		}
+		//没有共同算法时加上错误码，提示更换算法配置
+		if strings.Contains(err.Error(), "no common algorithm") {
+			return nil, &CodeError{Code: CodeAlgorithmMismatch, Err: fmt.Errorf("%w (algorithm profile %s)", err, profileName)}
 		}
-	}()
-	// Websocket超时定时器
-	stopTimer := time.NewTimer(timeout)
-	defer stopTimer.Stop() //停止定时器
+		return nil, err
+	}
+	sclient.Negotiated = kex.negotiated(profileName)
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
 
-	// 主循环
-	for {
-		select {
-		case <-stopCh: //信息stopCh信道
-			return
-		case <-stopTimer.C: //处理定时器信道
-			ws.WriteMessage(1, []byte(fmt.Sprintf("\u001B[33m%s\u001B[0m", closeTip)))
-			return
+// AddedHostKeys 返回本次连接中首次记录的主机密钥，包括跳板机
+func (sclient *SSHClient) AddedHostKeys() []HostKeyResult {
+	var added []HostKeyResult
+	for _, hop := range sclient.JumpHosts {
+		if hop.HostKey.Added {
+			added = append(added, hop.HostKey)
 		}
 	}
+	if sclient.HostKey.Added {
+		added = append(added, sclient.HostKey)
+	}
+	return added
 }
@@ -0,0 +1,108 @@
+// Package core : 核心包
+package core
+
+import (
+	"golang.org/x/crypto/ssh" //ssh库
+	"net"                     //监听
+	"strconv"                 //端口转换
+	"strings"                 //错误信息
+	"sync"                    //互斥锁
+	"testing"                 //测试
+	"time"                    //超时
+)
+
+// 接受tcp连接后不发送任何数据的服务器，返回主机及端口
+func startSilentServer(t *testing.T) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum
+}
+
+// 修改HandshakeTimeout，测试结束后恢复
+func setHandshakeTimeout(t *testing.T, timeout time.Duration) {
+	old := HandshakeTimeout
+	HandshakeTimeout = timeout
+	t.Cleanup(func() { HandshakeTimeout = old })
+}
+
+func TestDialHandshakeTimeout(t *testing.T) {
+	acceptAllHostKeys(t)
+	setHandshakeTimeout(t, 200*time.Millisecond)
+	silentHost, silentPort := startSilentServer(t)
+	jumpHost, jumpPort, _ := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true})
+	tests := []struct {
+		name   string
+		client SSHClient
+	}{
+		{"direct", SSHClient{Username: "u", IPAddress: silentHost, Port: silentPort, Password: "pass"}},
+		{"via jump host", SSHClient{Username: "u", IPAddress: silentHost, Port: silentPort, Password: "pass",
+			JumpHosts: []SSHClient{{Username: "j", IPAddress: jumpHost, Port: jumpPort}}}},
+		{"silent jump host", SSHClient{Username: "u", IPAddress: jumpHost, Port: jumpPort,
+			JumpHosts: []SSHClient{{Username: "j", IPAddress: silentHost, Port: silentPort}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sclient := tt.client
+			start := time.Now()
+			err := sclient.GenerateClient()
+			if err == nil {
+				sclient.Close()
+				t.Fatal("connected to a silent server")
+			}
+			if !strings.Contains(err.Error(), "timed out") {
+				t.Fatalf("got %v, want handshake timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("timed out after %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestDialChallengeNotTimed(t *testing.T) {
+	acceptAllHostKeys(t)
+	setHandshakeTimeout(t, 200*time.Millisecond)
+	host, port, _ := startTestSSHServer(t, otpServerConfig())
+	sclient := SSHClient{Username: "u", IPAddress: host, Port: port, LoginType: LoginKeyboardInteractive,
+		Challenge: func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			time.Sleep(500 * time.Millisecond) //等待浏览器回答的时间不计入握手超时
+			return []string{"123456"}, nil
+		}}
+	if err := sclient.GenerateClient(); err != nil {
+		t.Fatal(err)
+	}
+	defer sclient.Close()
+	//认证完成后不再有超时
+	time.Sleep(300 * time.Millisecond)
+	if _, _, err := sclient.Client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Fatalf("connection closed after login: %v", err)
+	}
+}
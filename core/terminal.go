@@ -0,0 +1,653 @@
+// Package core : 核心包
+package core
+
+import (
+	"crypto/rand"                  //随机终端ID
+	"encoding/hex"                 //终端ID编码
+	"encoding/json"                //关闭原因编码
+	"errors"                       //错误处理
+	"fmt"                          //格式化
+	"github.com/gorilla/websocket" //websocket库
+	"golang.org/x/crypto/ssh"      //ssh库
+	"io"                           //io操作
+	"log"                          //日志库
+	"sync"                         //互斥锁
+	"time"                         //时间日期库
+)
+
+// TerminalGrace websocket断开后终端在服务端保留多久，期间可以重新连接并回放错过的输出
+// 为0时websocket断开立即关闭终端
+var TerminalGrace = 5 * time.Minute
+
+// TerminalBufferSize 每个终端保留的最近输出字节数
+var TerminalBufferSize = 256 * 1024
+
+// 写websocket的超时，浏览器长时间不读取时视为断开
+const wsWriteTimeout = 10 * time.Second
+
+// 终端结束时websocket关闭帧使用的状态码，关闭原因为JSON编码的TerminalClose
+// shell以0退出时为1000，SSH错误为1011，服务端退出为1001，连接中断为CloseConnectionLost
+const (
+	CloseShellFailed = 4002 //shell以非0状态退出或被信号终止
+	CloseTimeout     = 4003 //超过连接时间限制
+)
+
+// TerminalClose 终端结束的原因，浏览器据此提示并决定是否重新连接
+type TerminalClose struct {
+	Reason     string `json:"reason"`               //exit、timeout、lost、error、shutdown
+	ExitStatus *int   `json:"exitStatus,omitempty"` //shell退出码
+	ExitSignal string `json:"exitSignal,omitempty"` //终止shell的信号名
+	Error      string `json:"error,omitempty"`      //SSH错误
+}
+
+// Terminal 与websocket解耦的终端，websocket断开后shell继续运行，重新连接时回放缓冲区中的输出
+// 所有者之外可以通过邀请链接连接多个观看者，输出同时转发给全部连接
+type Terminal struct {
+	ID          string            //终端ID
+	Created     time.Time         //创建时间
+	token       string            //所属会话令牌
+	sclient     *SSHClient        //与会话共享连接的SSH客户端
+	session     *ssh.Session      //SSH会话
+	stdin       io.WriteCloser    //标准输入管道
+	release     func()            //释放会话引用
+	mu          sync.Mutex        //保护以下字段并串行化websocket写入
+	buffer      *ringBuffer       //最近输出
+	subs        []*Subscriber     //连接到终端的websocket，全部断开时进入保留期
+	control     *Subscriber       //持有键盘控制权的连接，为空时由所有者控制
+	invites     map[string]string //邀请令牌到角色的映射
+	rec         *recorder         //终端录像，未开启时为空
+	commands    commandLine       //根据输入还原的命令行，写入审计日志
+	tail        []byte            //最近的输出，用于识别密码提示
+	decoder     *transcoder       //远程输出转换为UTF-8，远程为utf-8时为空
+	encoder     *transcoder       //浏览器输入转换为远程编码，远程为utf-8时为空
+	endReason   string            //结束原因
+	exitErr     error             //shell退出时session.Wait的返回值
+	closeTip    string            //超时关闭时的提示
+	detachedAt  time.Time         //最后一个websocket断开的时间
+	detachTimer *time.Timer       //保留期定时器
+	closed      bool              //是否已关闭
+	done        chan struct{}     //终端关闭时关闭
+}
+
+// TerminalInfo 终端状态
+type TerminalInfo struct {
+	ID          string    `json:"id"`          //终端ID
+	Created     time.Time `json:"created"`     //创建时间
+	Attached    bool      `json:"attached"`    //是否有websocket连接
+	Users       int       `json:"users"`       //连接数，包括共享的观看者
+	DetachedAt  time.Time `json:"detachedAt"`  //websocket断开的时间
+	Offset      int64     `json:"offset"`      //已输出的总字节数
+	BufferStart int64     `json:"bufferStart"` //缓冲区中最早输出的偏移
+}
+
+// TerminalRegistry 终端表
+type TerminalRegistry struct {
+	mu    sync.Mutex           //保护terms
+	terms map[string]*Terminal //终端ID到终端的映射
+}
+
+// Terminals 全局终端表
+var Terminals = &TerminalRegistry{terms: make(map[string]*Terminal)}
+
+// Get 按ID取终端，终端必须属于指定会话
+func (r *TerminalRegistry) Get(id, token string) *Terminal {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if term, ok := r.terms[id]; ok && term.token == token {
+		return term
+	}
+	return nil
+}
+
+// List 列出会话的全部终端
+func (r *TerminalRegistry) List(token string) []TerminalInfo {
+	r.mu.Lock()
+	terms := make([]*Terminal, 0)
+	for _, term := range r.terms {
+		if term.token == token {
+			terms = append(terms, term)
+		}
+	}
+	r.mu.Unlock()
+	infos := make([]TerminalInfo, 0, len(terms))
+	for _, term := range terms {
+		infos = append(infos, term.Info())
+	}
+	return infos
+}
+
+// Shutdown 服务端退出前结束全部终端，浏览器收到1001关闭帧后不再重新连接
+func (r *TerminalRegistry) Shutdown() {
+	r.mu.Lock()
+	terms := make([]*Terminal, 0, len(r.terms))
+	for _, term := range r.terms {
+		terms = append(terms, term)
+	}
+	r.mu.Unlock()
+	var conns []*TermConn
+	for _, term := range terms {
+		term.mu.Lock()
+		for _, sub := range term.subs {
+			conns = append(conns, sub.ws)
+		}
+		term.mu.Unlock()
+		term.finish("shutdown", nil)
+	}
+	//等待提示及关闭帧发送完，浏览器不读取时最多等待1秒
+	for _, conn := range conns {
+		<-conn.done
+	}
+}
+
+// NewTerminal 在会话的SSH连接上打开shell
+// sclient : 会话的SSH客户端副本，由终端负责关闭
+// release : 释放会话引用，终端关闭时调用
+// id : 终端ID，为空、已被占用或包含字母数字及-_以外的字符时生成随机ID
+// token : 所属会话令牌
+// rows, cols : 终端大小
+// timeout : 终端最长存活时间
+func NewTerminal(sclient *SSHClient, release func(), id, token string, rows, cols int, timeout time.Duration) (*Terminal, error) {
+	session, err := sclient.Client.NewSession() //创建SSH会话
+	if err != nil {
+		return nil, err
+	}
+	sclient.Session = session
+	term := &Terminal{
+		Created: time.Now(),
+		token:   token,
+		sclient: sclient,
+		session: session,
+		release: release,
+		buffer:  newRingBuffer(TerminalBufferSize),
+		invites: make(map[string]string),
+		decoder: newDecoder(sclient.charset()),
+		encoder: newEncoder(sclient.charset()),
+		done:    make(chan struct{}),
+	}
+	//登记终端，ID同时用于录像文件名
+	Terminals.mu.Lock()
+	if _, ok := Terminals.terms[id]; ok || !validTerminalID(id) {
+		id = newTerminalID()
+	}
+	term.ID = id
+	Terminals.terms[id] = term
+	Terminals.mu.Unlock()
+	fail := func(err error) (*Terminal, error) {
+		Terminals.mu.Lock()
+		delete(Terminals.terms, id)
+		Terminals.mu.Unlock()
+		if term.rec != nil {
+			term.rec.close()
+		}
+		session.Close()
+		return nil, err
+	}
+	//按录像策略录制终端输出
+	if shouldRecord(sclient) {
+		if term.rec, err = newRecorder(id, sclient, rows, cols); err != nil {
+			return fail(err)
+		}
+	}
+	if term.stdin, err = session.StdinPipe(); err != nil { //标准输入管道
+		return fail(err)
+	}
+	sclient.StdinPipe = term.stdin
+	session.Stdout = term //SSH会话标准输出流
+	session.Stderr = term //SSH会话标准错误流
+	//终端模式
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	//请求pty与远程主机上的会话的关联
+	if err := session.RequestPty("xterm", rows, cols, modes); err != nil {
+		return fail(err)
+	}
+	//在远程主机上启动一个登录Shell
+	if err := session.Shell(); err != nil {
+		return fail(err)
+	}
+	Audit(term.NewAuditEvent(AuditTerminalOpen))
+	go term.run(timeout)
+	return term, nil
+}
+
+// 终端ID只能包含字母数字及-_，长度不超过64
+func validTerminalID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// 生成随机终端ID
+func newTerminalID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Info 返回终端状态
+func (term *Terminal) Info() TerminalInfo {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return TerminalInfo{
+		ID:          term.ID,
+		Created:     term.Created,
+		Attached:    len(term.subs) > 0,
+		Users:       len(term.subs),
+		DetachedAt:  term.detachedAt,
+		Offset:      term.buffer.total,
+		BufferStart: term.buffer.start(),
+	}
+}
+
+// Write 实现io.Writer，SSH输出写入缓冲区并转发给全部连接的websocket
+// 任一连接排队的输出超过OutputMaxInflight时阻塞，暂停读取SSH输出直到浏览器读取，超时的连接被断开
+func (term *Terminal) Write(p []byte) (int, error) {
+	term.mu.Lock()
+	n := len(p)
+	//远程不是utf-8时先转换，缓冲区、录像及浏览器收到的都是UTF-8
+	if term.decoder != nil {
+		if p = term.decoder.convert(p); len(p) == 0 {
+			term.mu.Unlock()
+			return n, nil
+		}
+	}
+	term.buffer.Write(p)
+	if term.rec != nil {
+		term.rec.output(p)
+	}
+	if term.tail = append(term.tail, p...); len(term.tail) > 256 {
+		term.tail = append(term.tail[:0], term.tail[len(term.tail)-256:]...)
+	}
+	subs := append([]*Subscriber(nil), term.subs...)
+	for _, sub := range subs {
+		sub.ws.Output(p) //写失败的连接由发送协程关闭，Serve读取出错后断开
+	}
+	term.mu.Unlock()
+	//流控，等待时不持有锁，其他连接的输入及提示不受影响
+	for _, sub := range subs {
+		if !sub.ws.Wait(wsWriteTimeout) {
+			log.Printf("terminal %s: %s is not reading output, disconnected", term.ID, sub.Name)
+			term.mu.Lock()
+			term.detachLocked(sub)
+			term.mu.Unlock()
+		}
+	}
+	return n, nil
+}
+
+// Attach 以所有者身份连接websocket并回放offset之后的输出，已有所有者连接时替换为新连接
+// ws : websocket连接
+// name : 显示给其他连接的名字
+// offset : 浏览器已收到的输出字节数，早于缓冲区时从缓冲区开头回放
+// rows, cols : 终端大小，为0时不调整
+// closeTip : 超时关闭时的提示
+func (term *Terminal) Attach(ws *TermConn, name string, offset int64, rows, cols int, closeTip string) (*Subscriber, error) {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	if term.closed {
+		return nil, errors.New("terminal closed")
+	}
+	//同一终端只保留最新的所有者连接
+	for _, sub := range term.subs {
+		if sub.Role == RoleOwner {
+			sub.ws.CloseWith(websocket.ClosePolicyViolation, "attached from another connection")
+			term.removeLocked(sub)
+			break
+		}
+	}
+	term.closeTip = closeTip
+	sub := newSubscriber(ws, name, RoleOwner)
+	if err := term.attachLocked(sub, offset); err != nil {
+		return nil, err
+	}
+	if rows > 0 && cols > 0 && term.hasControlLocked(sub) {
+		term.resizeLocked(rows, cols)
+	}
+	return sub, nil
+}
+
+// 登记连接并回放offset之后的输出，调用时必须持有锁
+func (term *Terminal) attachLocked(sub *Subscriber, offset int64) error {
+	if term.detachTimer != nil {
+		term.detachTimer.Stop()
+		term.detachTimer = nil
+	}
+	//回放按单条消息的大小拆分后排队
+	for replay := term.buffer.Since(offset); len(replay) > 0; {
+		size := min(len(replay), outputFrameSize)
+		chunk := replay[:size]
+		replay = replay[size:]
+		if err := sub.ws.Output(chunk); err != nil {
+			sub.ws.Close()
+			term.startGraceLocked()
+			return err
+		}
+	}
+	term.noticeLocked("%s joined (%s)", sub.Name, sub.Role)
+	term.subs = append(term.subs, sub)
+	return nil
+}
+
+// Serve 处理websocket输入直到websocket断开
+// 所有者正常关闭(1000)时关闭终端，其他情况断开websocket，全部断开后在保留期内等待重新连接
+// 没有键盘控制权的连接的输入及调整大小被忽略
+func (term *Terminal) Serve(sub *Subscriber) {
+	for {
+		//按协议版本解码的浏览器消息
+		msg, err := sub.ws.Read()
+		if err != nil {
+			if sub.Role == RoleOwner && websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				term.Close()
+				return
+			}
+			term.mu.Lock()
+			term.detachLocked(sub)
+			term.mu.Unlock()
+			return
+		}
+		//心跳，v2回复pong
+		if msg.Type == MessagePing {
+			term.mu.Lock()
+			sub.ws.Send(ControlMessage{Type: MessagePong})
+			term.mu.Unlock()
+			continue
+		}
+		term.mu.Lock()
+		control := term.hasControlLocked(sub)
+		term.mu.Unlock()
+		if !control {
+			continue
+		}
+		switch msg.Type {
+		case MessageResize: //调整终端大小
+			term.mu.Lock()
+			term.resizeLocked(msg.Rows, msg.Cols)
+			term.mu.Unlock()
+			continue
+		case MessageSignal: //向shell发送信号，服务端不支持时忽略
+			if err := term.session.Signal(ssh.Signal(msg.Signal)); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		p := []byte(msg.Data)
+		//还原命令行写入审计日志，远程不是utf-8时转换后写入
+		term.mu.Lock()
+		commands := term.commands.feed(p, func() string { return string(term.tail) })
+		if term.encoder != nil {
+			p = term.encoder.convert(p)
+		}
+		term.mu.Unlock()
+		for _, command := range commands {
+			audit := term.NewAuditEvent(AuditCommand)
+			audit.Command = command
+			if sub.Role != RoleOwner {
+				audit.Actor, audit.Role = sub.Name, sub.Role
+			}
+			Audit(audit)
+		}
+		//普通消息直接写入输入流
+		if _, err := term.stdin.Write(p); err != nil {
+			return
+		}
+	}
+}
+
+// 调整终端大小并记录到录像，调用时必须持有锁
+func (term *Terminal) resizeLocked(rows, cols int) {
+	if err := term.session.WindowChange(rows, cols); err != nil {
+		log.Println(err)
+		return
+	}
+	if term.rec != nil {
+		term.rec.resize(rows, cols)
+	}
+}
+
+// 断开websocket，全部连接断开后保留期结束时关闭终端，调用时必须持有锁
+func (term *Terminal) detachLocked(sub *Subscriber) {
+	if !term.removeLocked(sub) {
+		return
+	}
+	term.noticeLocked("%s left", sub.Name)
+	term.startGraceLocked()
+}
+
+// 删除连接，控制者断开时控制权交还所有者，调用时必须持有锁
+func (term *Terminal) removeLocked(sub *Subscriber) bool {
+	for i, item := range term.subs {
+		if item == sub {
+			term.subs = append(term.subs[:i:i], term.subs[i+1:]...)
+			sub.ws.Close()
+			if term.control == sub {
+				term.control = nil
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// 没有连接时开始保留期，调用时必须持有锁
+func (term *Terminal) startGraceLocked() {
+	if len(term.subs) > 0 || term.closed || term.detachTimer != nil {
+		return
+	}
+	term.detachedAt = time.Now()
+	if TerminalGrace <= 0 {
+		go term.Close()
+		return
+	}
+	term.detachTimer = time.AfterFunc(TerminalGrace, term.Close)
+}
+
+// 等待shell退出、超时或SSH连接中断
+func (term *Terminal) run(timeout time.Duration) {
+	// 超时定时器
+	stopTimer := time.NewTimer(timeout)
+	defer stopTimer.Stop() //停止定时器
+	//keepalive检测与SSH服务器的连接是否中断
+	lostCh := keepalive(term.sclient.Client, term.done)
+	exitCh := make(chan error, 1)
+	go func() {
+		exitCh <- term.session.Wait()
+	}()
+	select {
+	case <-term.done: //终端已关闭
+	case err := <-exitCh: //shell退出，没有退出状态时为SSH错误
+		var exitErr *ssh.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			term.finish("error", err)
+		} else {
+			term.finish("exit", err)
+		}
+	case <-stopTimer.C: //处理定时器信道
+		term.finish("timeout", nil)
+	case <-lostCh: //SSH连接中断
+		term.sclient.Client.Close() //关闭已中断的连接，共享连接的会话随之从会话表中删除
+		term.finish("lost", nil)
+	}
+}
+
+// 按结束原因向浏览器发送提示及带状态码的关闭帧后关闭终端
+// reason : exit、timeout、lost、error或shutdown
+// err : shell退出时session.Wait的返回值或SSH错误
+func (term *Terminal) finish(reason string, err error) {
+	term.setEnd(reason, err)
+	info := TerminalClose{Reason: reason}
+	code, message := websocket.CloseNormalClosure, ""
+	switch reason {
+	case "exit":
+		info.ExitStatus, info.ExitSignal = exitStatus(err)
+		switch {
+		case info.ExitSignal != "":
+			code, message = CloseShellFailed, fmt.Sprintf("shell killed by signal %s", info.ExitSignal)
+		case info.ExitStatus != nil && *info.ExitStatus != 0:
+			code, message = CloseShellFailed, fmt.Sprintf("shell exited with status %d", *info.ExitStatus)
+		}
+		if message != "" {
+			message = "\r\n\u001B[33m" + message + "\u001B[0m\r\n"
+		}
+	case "timeout":
+		term.mu.Lock()
+		closeTip := term.closeTip
+		term.mu.Unlock()
+		code, message = CloseTimeout, fmt.Sprintf("\u001B[33m%s\u001B[0m", closeTip)
+	case "lost":
+		code, message = CloseConnectionLost, "\r\n\u001B[31mssh connection lost\u001B[0m\r\n"
+	case "error":
+		info.Error = err.Error()
+		code, message = websocket.CloseInternalServerErr, fmt.Sprintf("\r\n\u001B[31mssh error: %s\u001B[0m\r\n", err)
+	case "shutdown":
+		code, message = websocket.CloseGoingAway, "\r\n\u001B[33mwebssh server is shutting down\u001B[0m\r\n"
+	}
+	term.closeWith(code, info.encode(), message)
+}
+
+// 编码为关闭原因，关闭帧的原因最多123字节，超出时截断错误信息
+func (info TerminalClose) encode() string {
+	for {
+		data, _ := json.Marshal(info)
+		if len(data) <= 123 || info.Error == "" {
+			return string(data)
+		}
+		info.Error = info.Error[:len(info.Error)/2]
+	}
+}
+
+// NewAuditEvent 创建终端的审计事件，填入会话ID、终端ID及目标主机
+func (term *Terminal) NewAuditEvent(event string) AuditEvent {
+	audit := NewAuditEvent(event, term.sclient)
+	audit.Session, audit.Terminal = SessionID(term.token), term.ID
+	return audit
+}
+
+// 记录结束原因及shell退出状态，只记录第一次，关闭时写入录像及审计日志
+func (term *Terminal) setEnd(reason string, err error) {
+	term.mu.Lock()
+	if term.endReason == "" {
+		term.endReason, term.exitErr = reason, err
+	}
+	term.mu.Unlock()
+	if term.rec != nil {
+		term.rec.end(reason, err)
+	}
+}
+
+// 向全部websocket发送提示及关闭帧后关闭终端
+// code : websocket关闭状态码
+// reason : 关闭原因
+// message : 关闭前在终端中显示的提示，为空时不显示
+func (term *Terminal) closeWith(code int, reason, message string) {
+	term.mu.Lock()
+	for _, sub := range term.subs {
+		if message != "" {
+			sub.ws.Notice(message)
+		}
+		sub.ws.CloseWith(code, reason)
+	}
+	term.mu.Unlock()
+	term.Close()
+}
+
+// Close 关闭终端、websocket及SSH会话，并释放会话引用
+func (term *Terminal) Close() {
+	term.mu.Lock()
+	if term.closed {
+		term.mu.Unlock()
+		return
+	}
+	term.closed = true
+	if term.detachTimer != nil {
+		term.detachTimer.Stop()
+	}
+	subs := term.subs
+	term.subs, term.control, term.invites = nil, nil, nil
+	term.mu.Unlock()
+	for _, sub := range subs {
+		sub.ws.Close()
+	}
+	Terminals.mu.Lock()
+	if Terminals.terms[term.ID] == term {
+		delete(Terminals.terms, term.ID)
+	}
+	Terminals.mu.Unlock()
+	term.sclient.Close()
+	if term.rec != nil {
+		term.rec.close()
+	}
+	audit := term.NewAuditEvent(AuditTerminalClose)
+	audit.Reason = term.endReason
+	if audit.Reason == "" {
+		audit.Reason = "closed"
+	}
+	if audit.Reason == "exit" {
+		audit.ExitStatus, audit.ExitSignal = exitStatus(term.exitErr)
+	}
+	Audit(audit)
+	term.release()
+	close(term.done)
+}
+
+// 固定大小的环形缓冲区，记录最近的输出及总偏移
+type ringBuffer struct {
+	data  []byte //缓冲区
+	head  int    //下一次写入的位置
+	size  int    //已保存的字节数
+	total int64  //写入的总字节数
+}
+
+// 创建环形缓冲区
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, capacity)}
+}
+
+// 缓冲区中最早字节的偏移
+func (b *ringBuffer) start() int64 {
+	return b.total - int64(b.size)
+}
+
+// Write 写入数据，超出容量时覆盖最早的数据
+func (b *ringBuffer) Write(p []byte) {
+	b.total += int64(len(p))
+	capacity := len(b.data)
+	if capacity == 0 {
+		return
+	}
+	if len(p) >= capacity {
+		copy(b.data, p[len(p)-capacity:])
+		b.head, b.size = 0, capacity
+		return
+	}
+	n := copy(b.data[b.head:], p)
+	copy(b.data, p[n:])
+	b.head = (b.head + len(p)) % capacity
+	if b.size += len(p); b.size > capacity {
+		b.size = capacity
+	}
+}
+
+// Since 返回offset之后的数据
+func (b *ringBuffer) Since(offset int64) []byte {
+	if offset < b.start() {
+		offset = b.start()
+	}
+	if offset >= b.total {
+		return nil
+	}
+	n := int(b.total - offset)
+	out := make([]byte, n)
+	begin := (b.head - n + len(b.data)) % len(b.data)
+	copied := copy(out, b.data[begin:])
+	copy(out[copied:], b.data)
+	return out
+}
@@ -0,0 +1,67 @@
+// Package core : 核心包
+package core
+
+import (
+	"bytes"     //字节操作
+	"math/rand" //随机写入长度
+	"testing"   //测试
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		writes   []string
+		offset   int64  //Since的参数
+		want     string //Since的返回
+		start    int64  //缓冲区中最早字节的偏移
+	}{
+		{"empty", 8, nil, 0, "", 0},
+		{"within capacity", 8, []string{"abc", "de"}, 0, "abcde", 0},
+		{"offset inside", 8, []string{"abc", "de"}, 3, "de", 0},
+		{"offset at end", 8, []string{"abc", "de"}, 5, "", 0},
+		{"offset past end", 8, []string{"abc"}, 10, "", 0},
+		{"exactly full", 8, []string{"abcd", "efgh"}, 0, "abcdefgh", 0},
+		{"wraparound", 8, []string{"abcdef", "ghij"}, 0, "cdefghij", 2},
+		{"wraparound offset inside", 8, []string{"abcdef", "ghij"}, 5, "fghij", 2},
+		{"offset before start", 8, []string{"abcdef", "ghij", "kl"}, 1, "efghijkl", 4},
+		{"write larger than capacity", 4, []string{"ab", "cdefghij"}, 0, "ghij", 6},
+		{"many wraps", 3, []string{"ab", "cd", "ef", "g"}, 5, "fg", 4},
+		{"zero capacity", 0, []string{"abc"}, 0, "", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newRingBuffer(tt.capacity)
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+			}
+			if got := string(b.Since(tt.offset)); got != tt.want {
+				t.Errorf("Since(%d) = %q, want %q", tt.offset, got, tt.want)
+			}
+			if got := b.start(); got != tt.start {
+				t.Errorf("start() = %d, want %d", got, tt.start)
+			}
+		})
+	}
+}
+
+// 随机长度写入后与完整历史比较
+func TestRingBufferRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	b := newRingBuffer(100)
+	var history []byte
+	for i := 0; i < 1000; i++ {
+		p := make([]byte, rng.Intn(150))
+		rng.Read(p)
+		b.Write(p)
+		history = append(history, p...)
+		if b.total != int64(len(history)) {
+			t.Fatalf("total = %d, want %d", b.total, len(history))
+		}
+		offset := rng.Int63n(int64(len(history)) + 1)
+		want := history[max(offset, b.start()):]
+		if got := b.Since(offset); !bytes.Equal(got, want) {
+			t.Fatalf("write %d: Since(%d) returned %d bytes, want %d", i, offset, len(got), len(want))
+		}
+	}
+}
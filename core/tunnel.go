@@ -0,0 +1,622 @@
+// Package core : 核心包
+package core
+
+import (
+	"errors"                       //错误处理
+	"fmt"                          //格式化
+	"github.com/gorilla/websocket" //websocket库
+	"golang.org/x/crypto/ssh"      //ssh库
+	"io"                           //io操作
+	"log"                          //日志库
+	"net"                          //网络库
+	"strconv"                      //端口格式化
+	"strings"                      //字符串库
+	"sync"                         //互斥锁
+	"sync/atomic"                  //流量统计
+	"time"                         //时间日期库
+)
+
+// CodeTunnelNotFound 隧道不存在或已关闭的错误码
+const CodeTunnelNotFound = "TUNNEL_NOT_FOUND"
+
+// 隧道类型
+const (
+	TunnelLocal  = "local"  //在webssh服务器上监听端口，连接经SSH服务器转发到目标地址
+	TunnelStream = "ws"     //websocket二进制消息与目标地址之间的字节流
+	TunnelRemote = "remote" //在SSH服务器上监听端口(ssh -R)，连接转发回webssh服务器可以访问的目标地址
+	TunnelSocks  = "socks"  //在webssh服务器上监听SOCKS5代理(ssh -D)，按客户端请求的地址经SSH服务器连接
+)
+
+// 隧道配置
+var (
+	TunnelListenHost    = "127.0.0.1"  //本地转发隧道监听的地址，为空时只能使用websocket隧道
+	TunnelMax           = 10           //每个会话最多同时打开的隧道数，为0时不限制
+	TunnelMaxConns      = 100          //每个隧道最多同时转发的连接数，为0时不限制
+	tunnelRemoteTargets []tunnelTarget //远程转发允许的目标地址，为空时不能使用远程转发
+	tunnelSocksUsers    []string       //允许使用SOCKS5代理的web用户，*为全部用户，为空时不能使用
+)
+
+// 远程转发允许的目标地址
+type tunnelTarget struct {
+	network *net.IPNet //允许的IP地址范围，为空时允许全部地址
+	port    int        //允许的端口，为0时允许全部端口
+}
+
+// InitTunnels 初始化远程转发允许的目标地址及允许使用SOCKS5代理的web用户
+// targets : 主机:端口，逗号分隔，如127.0.0.1:*,10.0.0.*:8080,192.168.0.0/16:22，为空时不能使用远程转发
+// socksUsers : web用户名，逗号分隔，*为全部用户，为空时不能使用SOCKS5代理
+func InitTunnels(targets, socksUsers string) error {
+	var patterns []tunnelTarget
+	for _, item := range strings.Split(targets, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		pattern, err := parseTunnelTarget(item)
+		if err != nil {
+			return fmt.Errorf("invalid tunnel target pattern %q: %v", item, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	tunnelRemoteTargets = patterns
+	tunnelSocksUsers = nil
+	for _, item := range strings.Split(socksUsers, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			tunnelSocksUsers = append(tunnelSocksUsers, item)
+		}
+	}
+	return nil
+}
+
+// 解析远程转发允许的目标地址
+// 主机为IP地址、CIDR、末尾几段为*的IPv4地址或*，端口为数字或*
+func parseTunnelTarget(item string) (tunnelTarget, error) {
+	host, port, err := net.SplitHostPort(item)
+	if err != nil {
+		return tunnelTarget{}, err
+	}
+	var pattern tunnelTarget
+	if port != "*" {
+		if pattern.port, err = strconv.Atoi(port); err != nil || pattern.port < 1 || pattern.port > 65535 {
+			return tunnelTarget{}, fmt.Errorf("invalid port %q", port)
+		}
+	}
+	switch {
+	case host == "*":
+	case strings.Contains(host, "/"):
+		if _, pattern.network, err = net.ParseCIDR(host); err != nil {
+			return tunnelTarget{}, err
+		}
+	case strings.Contains(host, "*"):
+		//10.0.0.*相当于10.0.0.0/24，*只能替换末尾的整段
+		parts := strings.Split(host, ".")
+		fixed := 0
+		for fixed < len(parts) && parts[fixed] != "*" {
+			fixed++
+		}
+		for i := fixed; i < len(parts); i++ {
+			if parts[i] != "*" {
+				return tunnelTarget{}, fmt.Errorf("invalid host %q, * must replace whole trailing octets", host)
+			}
+			parts[i] = "0"
+		}
+		ip := net.ParseIP(strings.Join(parts, ".")).To4()
+		if len(parts) != 4 || ip == nil {
+			return tunnelTarget{}, fmt.Errorf("invalid host %q", host)
+		}
+		pattern.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(8*fixed, 32)}
+	default:
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return tunnelTarget{}, fmt.Errorf("invalid host %q, want an IP address or CIDR", host)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		pattern.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}
+	}
+	return pattern, nil
+}
+
+// 远程转发的目标地址是否允许，目标主机必须是IP地址，避免主机名解析到允许范围以外
+func remoteTargetAllowed(target string) bool {
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portText)
+	if ip == nil || err != nil || port < 1 || port > 65535 {
+		return false
+	}
+	for _, pattern := range tunnelRemoteTargets {
+		if (pattern.port == 0 || pattern.port == port) && (pattern.network == nil || pattern.network.Contains(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tunnel 经会话的SSH连接打开direct-tcpip通道的端口转发
+type Tunnel struct {
+	ID       string             //隧道ID
+	Type     string             //隧道类型
+	Listen   string             //监听地址，远程转发时为SSH服务器上的地址，websocket隧道为空
+	Target   string             //目标地址，远程转发时由webssh服务器连接，否则由SSH服务器连接
+	Created  time.Time          //创建时间
+	token    string             //所属会话令牌
+	sclient  *SSHClient         //与会话共享连接的SSH客户端
+	client   *ssh.Client        //打开通道的SSH连接，sclient关闭后仍可安全引用
+	release  func()             //释放会话引用
+	allow    []*net.IPNet       //允许连接监听端口的来源地址
+	username string             //SOCKS5认证用户名，为空时不认证
+	password string             //SOCKS5认证密码
+	listener net.Listener       //本地监听
+	ws       *websocket.Conn    //websocket隧道的连接
+	sent     atomic.Int64       //发往目标的字节数
+	received atomic.Int64       //从目标收到的字节数
+	mu       sync.Mutex         //保护以下字段
+	conns    map[io.Closer]bool //正在转发的连接及SSH通道，关闭隧道时全部关闭
+	active   int                //正在转发及正在连接目标的连接数
+	total    int                //累计转发的连接数
+	closed   bool               //是否已关闭
+	done     chan struct{}      //隧道关闭时关闭
+}
+
+// TunnelInfo 隧道状态
+type TunnelInfo struct {
+	ID       string    `json:"id"`                 //隧道ID
+	Type     string    `json:"type"`               //隧道类型
+	Listen   string    `json:"listen,omitempty"`   //监听地址
+	Target   string    `json:"target"`             //目标地址
+	Allow    []string  `json:"allow,omitempty"`    //允许连接的来源地址
+	Username string    `json:"username,omitempty"` //SOCKS5认证用户名
+	Password string    `json:"password,omitempty"` //SOCKS5认证密码，只在创建时返回
+	Created  time.Time `json:"created"`            //创建时间
+	Active   int       `json:"active"`             //正在转发的连接数
+	Total    int       `json:"total"`              //累计转发的连接数
+	Sent     int64     `json:"sent"`               //发往目标的字节数
+	Received int64     `json:"received"`           //从目标收到的字节数
+}
+
+// TunnelRegistry 隧道表
+type TunnelRegistry struct {
+	mu      sync.Mutex         //保护tunnels
+	tunnels map[string]*Tunnel //隧道ID到隧道的映射
+}
+
+// Tunnels 全局隧道表
+var Tunnels = &TunnelRegistry{tunnels: make(map[string]*Tunnel)}
+
+// Get 按ID取隧道，隧道必须属于指定会话
+func (r *TunnelRegistry) Get(id, token string) *Tunnel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tunnel, ok := r.tunnels[id]; ok && tunnel.token == token {
+		return tunnel
+	}
+	return nil
+}
+
+// List 列出会话的隧道
+func (r *TunnelRegistry) List(token string) []TunnelInfo {
+	r.mu.Lock()
+	var tunnels []*Tunnel
+	for _, tunnel := range r.tunnels {
+		if tunnel.token == token {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+	r.mu.Unlock()
+	list := make([]TunnelInfo, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		list = append(list, tunnel.Info())
+	}
+	return list
+}
+
+// CloseSession 关闭会话的全部隧道，会话退出、过期或SSH连接断开后隧道不再转发
+// token : 会话令牌
+func (r *TunnelRegistry) CloseSession(token string) {
+	r.mu.Lock()
+	var tunnels []*Tunnel
+	for _, tunnel := range r.tunnels {
+		if tunnel.token == token {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+	r.mu.Unlock()
+	for _, tunnel := range tunnels {
+		tunnel.Close()
+	}
+}
+
+// NewLocalTunnel 在webssh服务器上监听端口，每个连接经SSH服务器转发到目标地址
+// sclient : 与会话共享连接的SSH客户端，隧道关闭时释放
+// release : 释放会话引用
+// token : 所属会话令牌
+// port : 监听端口，为0时随机分配
+// target : 目标地址，主机:端口，由SSH服务器解析及连接
+// allow : 允许连接的来源IP或CIDR，为空时只允许创建隧道的浏览器IP
+func NewLocalTunnel(sclient *SSHClient, release func(), token string, port int, target string, allow []string) (*Tunnel, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	listener, nets, err := listenLocal(port, allow, sclient.ClientIP)
+	if err != nil {
+		return nil, err
+	}
+	tunnel := newTunnel(TunnelLocal, sclient, release, token, target)
+	tunnel.Listen, tunnel.listener, tunnel.allow = listener.Addr().String(), listener, nets
+	if err := Tunnels.register(tunnel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go tunnel.accept(func(net.Conn) (net.Conn, error) {
+		return tunnel.client.Dial("tcp", tunnel.Target)
+	})
+	return tunnel, nil
+}
+
+// 在webssh服务器上监听端口并解析允许的来源地址
+// allow : 允许连接的来源IP或CIDR，为空时只允许clientIP
+func listenLocal(port int, allow []string, clientIP string) (net.Listener, []*net.IPNet, error) {
+	if TunnelListenHost == "" {
+		return nil, nil, errors.New("local tunnels are disabled, use a websocket tunnel")
+	}
+	if len(allow) == 0 && clientIP != "" {
+		allow = []string{clientIP}
+	}
+	nets, err := parseAllow(allow)
+	if err != nil {
+		return nil, nil, err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(TunnelListenHost, strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return listener, nets, nil
+}
+
+// NewRemoteTunnel 请求SSH服务器监听端口(tcpip-forward)，每个连接转发回webssh服务器可以访问的目标地址
+// bind : SSH服务器上的监听地址，非回环地址需要服务端开启GatewayPorts
+// port : 监听端口，为0时由SSH服务器分配
+// target : 目标地址，主机:端口，必须匹配-trt中的通配符
+func NewRemoteTunnel(sclient *SSHClient, release func(), token, bind string, port int, target string) (*Tunnel, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	if !remoteTargetAllowed(target) {
+		return nil, fmt.Errorf("remote forwarding to %s is not allowed", target)
+	}
+	if bind == "" {
+		bind = "127.0.0.1"
+	}
+	listener, err := sclient.Client.Listen("tcp", net.JoinHostPort(bind, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	tunnel := newTunnel(TunnelRemote, sclient, release, token, target)
+	tunnel.Listen, tunnel.listener = listener.Addr().String(), listener
+	if err := Tunnels.register(tunnel); err != nil {
+		listener.Close() //取消SSH服务器上的监听
+		return nil, err
+	}
+	go tunnel.accept(func(net.Conn) (net.Conn, error) {
+		return net.DialTimeout("tcp", tunnel.Target, 10*time.Second)
+	})
+	return tunnel, nil
+}
+
+// NewStreamTunnel 打开到目标地址的通道，由Serve与websocket之间转发
+// ws : websocket连接，二进制消息为字节流
+func NewStreamTunnel(sclient *SSHClient, release func(), token, target string, ws *websocket.Conn) (*Tunnel, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	tunnel := newTunnel(TunnelStream, sclient, release, token, target)
+	tunnel.ws = ws
+	if err := Tunnels.register(tunnel); err != nil {
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// 创建隧道
+func newTunnel(kind string, sclient *SSHClient, release func(), token, target string) *Tunnel {
+	return &Tunnel{
+		ID:      newTerminalID(),
+		Type:    kind,
+		Target:  target,
+		Created: time.Now(),
+		token:   token,
+		sclient: sclient,
+		client:  sclient.Client,
+		release: release,
+		conns:   make(map[io.Closer]bool),
+		done:    make(chan struct{}),
+	}
+}
+
+// 登记隧道，SSH连接断开时关闭隧道，超过每个会话的隧道数限制时返回错误
+func (r *TunnelRegistry) register(tunnel *Tunnel) error {
+	r.mu.Lock()
+	count := 0
+	for _, item := range r.tunnels {
+		if item.token == tunnel.token {
+			count++
+		}
+	}
+	if TunnelMax > 0 && count >= TunnelMax {
+		r.mu.Unlock()
+		return fmt.Errorf("too many tunnels, at most %d per session", TunnelMax)
+	}
+	r.tunnels[tunnel.ID] = tunnel
+	r.mu.Unlock()
+	audit := NewAuditEvent(AuditTunnelOpen, tunnel.sclient)
+	audit.Session, audit.Tunnel, audit.Target = SessionID(tunnel.token), tunnel.ID, tunnel.Target
+	Audit(audit)
+	go func() {
+		select {
+		case <-tunnel.done:
+		case <-tunnel.sclient.connDone():
+			tunnel.Close()
+		}
+	}()
+	return nil
+}
+
+// 解析允许的来源地址，单个IP视为只包含该地址的网段
+func parseAllow(allow []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range allow {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allow address %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow address %q: %v", item, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// 来源地址是否在允许的网段中
+func (tunnel *Tunnel) allowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range tunnel.allow {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// 接受监听端口的连接，本地转发拒绝不在允许网段中的来源，超过连接数限制时拒绝
+// dial : 连接目标地址，参数为接受的连接，动态转发时在其中完成SOCKS5握手
+func (tunnel *Tunnel) accept(dial func(net.Conn) (net.Conn, error)) {
+	for {
+		conn, err := tunnel.listener.Accept()
+		if err != nil {
+			tunnel.Close()
+			return
+		}
+		if tunnel.Type != TunnelRemote && !tunnel.allowed(conn.RemoteAddr()) {
+			log.Printf("tunnel %s: rejected connection from %s", tunnel.ID, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if !tunnel.reserve() {
+			log.Printf("tunnel %s: too many connections, rejected %s", tunnel.ID, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go tunnel.forward(conn, dial)
+	}
+}
+
+// 连接目标地址并双向转发
+// local : 监听端口接受的连接
+func (tunnel *Tunnel) forward(local net.Conn, dial func(net.Conn) (net.Conn, error)) {
+	remote, err := dial(local)
+	if err != nil {
+		log.Printf("tunnel %s: %v", tunnel.ID, err)
+		tunnel.untrack(local) //释放accept中占用的连接数
+		return
+	}
+	if !tunnel.track(local, remote) {
+		return
+	}
+	defer tunnel.untrack(local, remote)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(&countWriter{w: remote, n: &tunnel.sent}, local)
+		closeWrite(remote)
+	}()
+	io.Copy(&countWriter{w: local, n: &tunnel.received}, remote)
+	closeWrite(local)
+	wg.Wait()
+}
+
+// Serve 在websocket与目标地址之间转发，websocket断开或目标关闭连接时关闭隧道
+func (tunnel *Tunnel) Serve() {
+	defer tunnel.Close()
+	remote, err := tunnel.client.Dial("tcp", tunnel.Target)
+	if err != nil {
+		tunnel.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()), time.Now().Add(time.Second))
+		return
+	}
+	tunnel.reserve() //websocket隧道只有一个连接，不受连接数限制
+	if !tunnel.track(remote) {
+		return
+	}
+	defer tunnel.untrack(remote)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := remote.Read(buf)
+			if n > 0 {
+				tunnel.received.Add(int64(n))
+				tunnel.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if tunnel.ws.WriteMessage(websocket.BinaryMessage, buf[:n]) != nil {
+					break
+				}
+			}
+			if err != nil {
+				//目标关闭连接，正常关闭websocket
+				tunnel.ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "eof"), time.Now().Add(time.Second))
+				break
+			}
+		}
+		tunnel.ws.Close()
+	}()
+	for {
+		_, p, err := tunnel.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if _, err := remote.Write(p); err != nil {
+			return
+		}
+		tunnel.sent.Add(int64(len(p)))
+	}
+}
+
+// 占用一个连接数，达到TunnelMaxConns时返回false
+// 接受连接时立即占用，正在连接目标的连接也计入，突发的连接不会超过限制
+func (tunnel *Tunnel) reserve() bool {
+	tunnel.mu.Lock()
+	defer tunnel.mu.Unlock()
+	if TunnelMaxConns > 0 && tunnel.active >= TunnelMaxConns {
+		return false
+	}
+	tunnel.active++
+	return true
+}
+
+// 记录正在转发的连接，连接数已由reserve占用，隧道已关闭时关闭连接、释放连接数并返回false
+func (tunnel *Tunnel) track(conns ...io.Closer) bool {
+	tunnel.mu.Lock()
+	defer tunnel.mu.Unlock()
+	if tunnel.closed {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		tunnel.active--
+		return false
+	}
+	for _, conn := range conns {
+		tunnel.conns[conn] = true
+	}
+	tunnel.total++
+	return true
+}
+
+// 关闭并删除连接，释放占用的连接数
+func (tunnel *Tunnel) untrack(conns ...io.Closer) {
+	tunnel.mu.Lock()
+	for _, conn := range conns {
+		delete(tunnel.conns, conn)
+	}
+	tunnel.active--
+	tunnel.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Info 返回隧道状态
+func (tunnel *Tunnel) Info() TunnelInfo {
+	tunnel.mu.Lock()
+	defer tunnel.mu.Unlock()
+	info := TunnelInfo{
+		ID:       tunnel.ID,
+		Type:     tunnel.Type,
+		Listen:   tunnel.Listen,
+		Target:   tunnel.Target,
+		Username: tunnel.username,
+		Created:  tunnel.Created,
+		Active:   tunnel.active,
+		Total:    tunnel.total,
+		Sent:     tunnel.sent.Load(),
+		Received: tunnel.received.Load(),
+	}
+	for _, ipNet := range tunnel.allow {
+		info.Allow = append(info.Allow, ipNet.String())
+	}
+	return info
+}
+
+// Close 关闭监听、websocket及全部转发连接，并释放会话引用
+func (tunnel *Tunnel) Close() {
+	tunnel.mu.Lock()
+	if tunnel.closed {
+		tunnel.mu.Unlock()
+		return
+	}
+	tunnel.closed = true
+	conns := tunnel.conns
+	tunnel.conns = make(map[io.Closer]bool)
+	tunnel.mu.Unlock()
+	if tunnel.listener != nil {
+		tunnel.listener.Close()
+	}
+	if tunnel.ws != nil {
+		tunnel.ws.Close()
+	}
+	for conn := range conns {
+		conn.Close()
+	}
+	Tunnels.mu.Lock()
+	if Tunnels.tunnels[tunnel.ID] == tunnel {
+		delete(Tunnels.tunnels, tunnel.ID)
+	}
+	Tunnels.mu.Unlock()
+	audit := NewAuditEvent(AuditTunnelClose, tunnel.sclient)
+	audit.Session, audit.Tunnel, audit.Target = SessionID(tunnel.token), tunnel.ID, tunnel.Target
+	Audit(audit)
+	tunnel.sclient.Close()
+	tunnel.release()
+	close(tunnel.done)
+}
+
+// 统计写入字节数
+type countWriter struct {
+	w io.Writer     //实际写入的连接
+	n *atomic.Int64 //累计字节数
+}
+
+// Write 写入并累加字节数
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// 关闭连接的写入方向，对端读到EOF后仍可继续发送，不支持半关闭时直接关闭
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,67 @@
+// Package core : 核心包
+package core
+
+import (
+	"testing" //测试
+)
+
+func TestRemoteTargetAllowed(t *testing.T) {
+	if err := InitTunnels("127.0.0.1:*, 10.0.0.*:8080, 192.168.0.0/16:22, [::1]:443, *:9000", ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { InitTunnels("", "") })
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"127.0.0.1:80", true},
+		{"127.0.0.1:65535", true},
+		{"127.0.0.2:80", false},
+		{"10.0.0.5:8080", true},
+		{"10.0.0.5:08080", true},
+		{"10.0.0.5:8081", false},
+		{"10.0.1.5:8080", false},
+		{"10.0.0.evil.example.com:8080", false}, //*不能匹配主机名
+		{"10.0.0.1.nip.io:8080", false},
+		{"localhost:80", false},
+		{"192.168.3.4:22", true},
+		{"192.169.0.1:22", false},
+		{"[::1]:443", true},
+		{"[::2]:443", false},
+		{"[::ffff:127.0.0.1]:80", true}, //IPv4映射地址按IPv4匹配
+		{"8.8.8.8:9000", true},
+		{"[2001:db8::1]:9000", true},
+		{"example.com:9000", false},
+		{"127.0.0.1", false},
+		{"127.0.0.1:0", false},
+		{"127.0.0.1:http", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := remoteTargetAllowed(tt.target); got != tt.want {
+				t.Fatalf("remoteTargetAllowed(%q) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitTunnelsInvalid(t *testing.T) {
+	t.Cleanup(func() { InitTunnels("", "") })
+	for _, targets := range []string{
+		"10.0.*.1:80",
+		"10.0.*:80",
+		"host.example.com:80",
+		"10.0.0.*.example.com:80",
+		"10.0.0.1",
+		"10.0.0.1:0",
+		"10.0.0.1:http",
+		"10.0.0.0/33:80",
+		"[::*]:80",
+	} {
+		t.Run(targets, func(t *testing.T) {
+			if err := InitTunnels(targets, ""); err == nil {
+				t.Fatalf("InitTunnels(%q) accepted", targets)
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ require (
 	github.com/gorilla/websocket v1.5.3 //websocket包
 	github.com/pkg/sftp v1.13.6 //sftp文件上传下载包
 	golang.org/x/crypto v0.24.0 //crypto加密包
+	golang.org/x/net v0.25.0 //socks5代理
+	golang.org/x/text v0.16.0 //GBK、Big5等字符编码转换
 )
 
 require (
@@ -32,9 +34,7 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
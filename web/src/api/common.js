@@ -1,4 +1,29 @@
 import request from '@/utils/request'
-export function checkSSH(sshInfo) {
-    return request.get(`/check?sshInfo=${sshInfo}`)
+export function createSession(sshInfo) {
+    const data = new URLSearchParams()
+    data.append('sshInfo', sshInfo)
+    return request.post('/session', data)
+}
+export function closeSession(token) {
+    return request.delete(`/session?token=${token}`)
+}
+export function checkSSH(token) {
+    return request.get(`/check?token=${token}`)
+}
+export function createInvite(token, id, role) {
+    const data = new URLSearchParams()
+    data.append('token', token)
+    data.append('id', id)
+    data.append('role', role)
+    return request.post('/term/invite', data)
+}
+export function termUsers(token, id) {
+    return request.get(`/term/users?token=${token}&id=${id}`)
+}
+export function setControl(token, id, user) {
+    const data = new URLSearchParams()
+    data.append('token', token)
+    data.append('id', id)
+    data.append('user', user)
+    return request.post('/term/control', data)
 }
@@ -1,4 +1,4 @@
 import request from '@/utils/request'
-export function fileList(path, sshInfo) {
-    return request.get(`/file/list?path=${path}&sshInfo=${sshInfo}`)
+export function fileList(path, token) {
+    return request.get(`/file/list?path=${path}&token=${token}`)
 }
@@ -0,0 +1,4 @@
+import request from '@/utils/request'
+export function recordList(user, host, from, to) {
+    return request.get(`/record/list?user=${encodeURIComponent(user)}&host=${encodeURIComponent(host)}&from=${from}&to=${to}`)
+}
@@ -3,11 +3,24 @@ export default {
     portTip: 'input port',
     nameTip: 'please input username',
     inputTip: 'please input ',
-    keyTip: 'please paste privateKey content',
+    keyTip: 'please paste privateKey content (OpenSSH, PEM or PuTTY .ppk)',
+    passphraseTip: 'passphrase of encrypted privateKey (optional)',
     wsClose: 'websocket connection disconnected!',
+    sshLost: 'ssh connection lost, please reconnect!',
+    shellExited: 'shell exited with status {status}',
+    shellKilled: 'shell killed by signal {signal}',
+    termTimeout: 'terminal closed: connection time limit reached',
+    sshError: 'ssh error: {error}',
+    serverShutdown: 'webssh server is shutting down',
     notCloseWindows: 'please do not close windows',
     unlockClose: 'please unlock to close tab',
     clickSelectFile: 'click to select upload file',
     clickSelectFolder: 'click to select upload folder',
-    uploadFinish: ' upload finish'
+    uploadFinish: ' upload finish',
+    ReadOnlyLink: 'Read-only link',
+    ReadWriteLink: 'Read-write link',
+    GiveControl: 'Give control',
+    InControl: 'In control',
+    StartTime: 'Start time',
+    ExitStatus: 'Exit status'
 }
\ No newline at end of file
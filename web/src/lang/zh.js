@@ -25,9 +25,16 @@ export default {
     portTip: '请输入端口',
     nameTip: '请输入用户名',
     inputTip: '请输入',
-    keyTip: '请粘贴私钥内容',
+    keyTip: '请粘贴私钥内容(支持OpenSSH、PEM及PuTTY .ppk格式)',
+    passphraseTip: '私钥密码短语(私钥未加密时不填)',
     SelectFile: '选择文件',
     wsClose: 'websocket连接已断开!',
+    sshLost: 'ssh连接已中断, 请重新连接!',
+    shellExited: 'shell已退出, 退出状态{status}',
+    shellKilled: 'shell被信号{signal}终止',
+    termTimeout: '超过连接时间限制, 终端已关闭',
+    sshError: 'ssh错误: {error}',
+    serverShutdown: 'webssh服务正在关闭',
     uploadPath: '当前上传目录',
     uploadFinish: '上传完成',
     to: '到',
@@ -37,5 +44,21 @@ export default {
     clickSelectFile: '点击选择文件',
     clickSelectFolder: '点击选择文件夹',
     uploadFile: '上传文件',
-    uploadFolder: '上传文件夹'
+    uploadFolder: '上传文件夹',
+    Share: '共享',
+    Shared: '共享终端',
+    Role: '角色',
+    ReadOnlyLink: '只读邀请链接',
+    ReadWriteLink: '可写邀请链接',
+    GiveControl: '转移控制权',
+    InControl: '控制中',
+    Recordings: '录像',
+    Recording: '录像中',
+    From: '从',
+    To: '到',
+    StartTime: '开始时间',
+    Duration: '时长',
+    ExitStatus: '退出状态',
+    Play: '回放',
+    Export: '导出'
 }
\ No newline at end of file
@@ -5,6 +5,8 @@ export default {
             "ipaddress":"${state.sshInfo.host}", 
             "port":${state.sshInfo.port}, 
             "password":"${state.sshInfo.password.replace(/[\n]/g, '\\n')}",
+            "passphrase":${JSON.stringify(state.sshInfo.passphrase || '')},
+            "encoding":${JSON.stringify(state.sshInfo.encoding || 'utf-8')},
             "logintype":${state.sshInfo.logintype === undefined ? 0 : state.sshInfo.logintype}
         }`
     )
@@ -1,6 +1,7 @@
 export default {
     SET_PASS(state, pass) {
         state.sshInfo.password = pass
+        state.sshInfo.passphrase = ''
     },
     SET_LIST(state, list) {
         state.sshList = list
@@ -8,12 +9,15 @@ export default {
     },
     SET_TERMLIST(state, list) {
         state.termList = list
+        // 页面刷新后恢复标签页并重新连接服务端保留的终端
+        sessionStorage.setItem('termList', JSON.stringify(list))
     },
     SET_SSH(state, ssh) {
         state.sshInfo.host = ssh.host
         state.sshInfo.username = ssh.username
         state.sshInfo.port = ssh.port
         state.sshInfo.logintype = ssh.logintype
+        state.sshInfo.encoding = ssh.encoding || 'utf-8'
         if (ssh.password !== undefined) {
             state.sshInfo.password = ssh.password
         }
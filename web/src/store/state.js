@@ -6,10 +6,12 @@ export default {
         username: 'root',
         port: 22,
         password: '',
-        logintype: 0
+        passphrase: '',
+        logintype: 0,
+        encoding: 'utf-8'
     },
     sshList: Object.prototype.hasOwnProperty.call(localStorage, 'sshList') ? localStorage.getItem('sshList') : null,
-    termList: [],
+    termList: JSON.parse(sessionStorage.getItem('termList') || '[]'),
     currentTab: {},
     language: getLanguage()
 }
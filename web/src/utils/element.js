@@ -21,7 +21,10 @@ import {
     Tabs,
     TabPane,
     Divider,
-    Tooltip
+    Tooltip,
+    Slider,
+    Select,
+    Option
 } from 'element-ui'
 const element = {
     install: function (Vue) {
@@ -46,6 +49,9 @@ const element = {
         Vue.use(TabPane)
         Vue.use(Divider)
         Vue.use(Tooltip)
+        Vue.use(Slider)
+        Vue.use(Select)
+        Vue.use(Option)
         Vue.prototype.$message = Message
     }
 }
@@ -0,0 +1,55 @@
+// 终端websocket协议v2: 二进制消息为输入及输出字节流, 文本消息为JSON控制消息
+export const PROTOCOL_VERSION = 2
+
+// 发送控制消息, 如 { type: 'resize', rows, cols }、{ type: 'ping' }、{ type: 'signal', signal: 'INT' }
+export function sendControl(ws, msg) {
+    if (ws !== null && ws.readyState === 1) {
+        ws.send(JSON.stringify(msg))
+    }
+}
+
+// 替代xterm-addon-attach, 按协议v2连接终端与websocket
+export class ProtocolAddon {
+    constructor(socket) {
+        this.socket = socket
+        this.socket.binaryType = 'arraybuffer'
+        this.disposables = []
+    }
+
+    activate(terminal) {
+        const encoder = new TextEncoder()
+        const onMessage = (e) => {
+            if (typeof e.data !== 'string') {
+                terminal.write(new Uint8Array(e.data))
+                return
+            }
+            let msg = {}
+            try {
+                msg = JSON.parse(e.data)
+            } catch (err) {
+                return
+            }
+            // webssh自己的提示直接显示在终端中, 无效的控制消息只记录日志
+            if (msg.type === 'notice') {
+                terminal.write(msg.message)
+            } else if (msg.type === 'error') {
+                console.log(Date(), 'control error', msg.message)
+            }
+        }
+        this.socket.addEventListener('message', onMessage)
+        this.disposables.push({ dispose: () => this.socket.removeEventListener('message', onMessage) })
+        this.disposables.push(terminal.onData(data => this.send(encoder.encode(data))))
+        this.disposables.push(terminal.onBinary(data => this.send(Uint8Array.from(data, c => c.charCodeAt(0) & 255))))
+    }
+
+    send(data) {
+        if (this.socket.readyState === 1) {
+            this.socket.send(data)
+        }
+    }
+
+    dispose() {
+        this.disposables.forEach(d => d.dispose())
+        this.disposables = []
+    }
+}